@@ -0,0 +1,38 @@
+package MegaGormAudit
+
+import (
+	"context"
+	"gorm.io/gorm"
+)
+
+type actorKey struct{}
+
+// WithActor returns a copy of ctx carrying the user responsible for the changes made with it.
+func WithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+// actorFromContext returns the actor set by WithActor in ctx.
+func actorFromContext(ctx context.Context) string {
+	actor, _ := ctx.Value(actorKey{}).(string)
+	return actor
+}
+
+// contextActor carries the LastChangedUser of the auditable model being saved in the statement context,
+// so changes made by gorm to its associations are attributed to it.
+func contextActor(db *gorm.DB) {
+	if db.Statement.Model == nil || actorFromContext(db.Statement.Context) != "" {
+		return
+	}
+
+	if actor := actorOf(db.Statement.Model); actor != "" {
+		db.Statement.Context = WithActor(db.Statement.Context, actor)
+	}
+}
+
+// stampActor sets the LastChangedUser of the auditable models created to the actor of the statement context.
+func stampActor(db *gorm.DB) {
+	if actor := actorFromContext(db.Statement.Context); actor != "" {
+		stampAuditableField(db, "LastChangedUser", actor)
+	}
+}
@@ -0,0 +1,164 @@
+package MegaGormAudit
+
+import (
+	"context"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+const archiveSuffix = "_archive"
+
+// Archive moves the superseded versions of model older than olderThan to the archive table
+// (<table>_archive), which is created with the same columns when it doesn't exist.
+// The original versions are kept, so archived versions still reference their audit parent, and chain
+// anchors are recorded, so the chain can still be verified without the archive table. It returns the number of archived versions.
+func Archive(ctx context.Context, db *gorm.DB, model interface{}, olderThan time.Duration) (int64, error) {
+	db = db.WithContext(ctx)
+	auditSchema, err := auditableSchema(db, model)
+	if err != nil {
+		return 0, err
+	}
+
+	archive, err := ensureArchiveTable(db, model, auditSchema)
+	if err != nil {
+		return 0, err
+	}
+
+	rows, err := supersededVersions(db, auditSchema.Table)
+	if err != nil {
+		return 0, err
+	}
+
+	cutoff := db.NowFunc().Add(-olderThan).UnixMilli()
+	var ids []uint
+	for _, row := range rows {
+		if row.DeletedAt <= cutoff {
+			ids = append(ids, row.ID)
+		}
+	}
+
+	if err := recordAnchors(db, auditSchema.Table, ids); err != nil {
+		return 0, err
+	}
+
+	columns := make([]string, 0, len(auditSchema.DBNames))
+	for _, name := range auditSchema.DBNames {
+		columns = append(columns, db.Statement.Quote(name))
+	}
+	columnList := strings.Join(columns, ",")
+
+	return inBatches(db, ids, defaultBatchSize, func(tx *gorm.DB, batch []uint) (int64, error) {
+		err := tx.Exec("INSERT INTO ? ("+columnList+") SELECT "+columnList+" FROM ? WHERE id IN ?",
+			clause.Table{Name: archive}, clause.Table{Name: auditSchema.Table}, batch).Error
+		if err != nil {
+			return 0, err
+		}
+
+		result := AllowBypass(tx).Exec("DELETE FROM ? WHERE id IN ?", clause.Table{Name: auditSchema.Table}, batch)
+		return result.RowsAffected, result.Error
+	})
+}
+
+// History loads into dest, a pointer to a slice of the model type, every version of the record
+// represented by model, including the archived ones, ordered from the original to the most recent.
+// Fields encrypted in superseded versions are decrypted with the plugin Encrypter.
+func History(ctx context.Context, db *gorm.DB, model interface{}, dest interface{}) error {
+	db = db.WithContext(ctx)
+	auditSchema, err := auditableSchema(db, model)
+	if err != nil {
+		return err
+	}
+
+	auditableModel := auditableModelOf(model)
+	rootID := auditableModel.ID
+	if auditableModel.AuditParentID != nil {
+		rootID = *auditableModel.AuditParentID
+	}
+
+	if err := findVersions(db, auditSchema.Table, dest, "id = ? OR audit_parent_id = ?", rootID, rootID); err != nil {
+		return err
+	}
+	return pluginOf(db).decryptVersions(ctx, auditSchema, reflect.ValueOf(dest).Elem())
+}
+
+// findVersions loads into dest the versions of table and of its archive table
+// that match conds, ordered by ID.
+func findVersions(db *gorm.DB, table string, dest interface{}, conds ...interface{}) error {
+	query := db.Unscoped().Table(table).Order("id")
+	if len(conds) > 0 {
+		query = query.Where(conds[0], conds[1:]...)
+	}
+	if err := query.Find(dest).Error; err != nil {
+		return err
+	}
+
+	archive := archiveTable(table)
+	if !db.Migrator().HasTable(archive) {
+		return nil
+	}
+
+	versions := reflect.ValueOf(dest).Elem()
+	archived := reflect.New(versions.Type())
+	query = db.Unscoped().Table(archive)
+	if len(conds) > 0 {
+		query = query.Where(conds[0], conds[1:]...)
+	}
+	if err := query.Find(archived.Interface()).Error; err != nil {
+		return err
+	}
+
+	versions.Set(reflect.AppendSlice(versions, archived.Elem()))
+	sort.SliceStable(versions.Interface(), func(i, j int) bool {
+		return versionID(versions.Index(i)) < versionID(versions.Index(j))
+	})
+	return nil
+}
+
+func archiveTable(table string) string {
+	return table + archiveSuffix
+}
+
+// ensureArchiveTable creates the archive table of the auditable schema, or adds the columns it is missing.
+// Archive columns have the same types of the auditable table, without its indexes and constraints.
+func ensureArchiveTable(db *gorm.DB, model interface{}, auditSchema *schema.Schema) (string, error) {
+	archive := archiveTable(auditSchema.Table)
+	migrator := db.Table(archive).Migrator()
+
+	if !migrator.HasTable(archive) {
+		sql := "CREATE TABLE ? ("
+		values := []interface{}{clause.Table{Name: archive}}
+		for _, name := range auditSchema.DBNames {
+			sql += "? ?,"
+			values = append(values, clause.Column{Name: name}, archiveDataType(db, auditSchema.FieldsByDBName[name]))
+		}
+		sql += "PRIMARY KEY (?))"
+		values = append(values, clause.Column{Name: "id"})
+
+		return archive, db.Exec(sql, values...).Error
+	}
+
+	for _, name := range auditSchema.DBNames {
+		if !migrator.HasColumn(model, name) {
+			err := db.Exec("ALTER TABLE ? ADD ? ?", clause.Table{Name: archive}, clause.Column{Name: name}, archiveDataType(db, auditSchema.FieldsByDBName[name])).Error
+			if err != nil {
+				return archive, err
+			}
+		}
+	}
+	return archive, nil
+}
+
+func archiveDataType(db *gorm.DB, field *schema.Field) clause.Expr {
+	archiveField := *field
+	archiveField.AutoIncrement = false
+	return clause.Expr{SQL: db.Dialector.DataTypeOf(&archiveField)}
+}
+
+func versionID(version reflect.Value) uint64 {
+	return reflect.Indirect(version).FieldByName("ID").Uint()
+}
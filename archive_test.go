@@ -0,0 +1,302 @@
+package MegaGormAudit
+
+import (
+	"context"
+	"errors"
+	"gorm.io/gorm"
+	"testing"
+	"time"
+)
+
+func TestArchive(t *testing.T) {
+
+	type Player struct {
+		AuditableModel
+		Name string
+	}
+
+	tests := []struct {
+		name         string
+		updates      int
+		olderThan    time.Duration
+		twice        bool
+		wantArchived int64
+		wantIDs      []uint
+	}{
+		{
+			name:         "Success, archive superseded versions",
+			updates:      3,
+			wantArchived: 2,
+			wantIDs:      []uint{1, 4},
+		},
+		{
+			name:         "Success, archive on existing archive table",
+			updates:      3,
+			twice:        true,
+			wantArchived: 0,
+			wantIDs:      []uint{1, 4},
+		},
+		{
+			name:         "Success, recent versions are not archived",
+			updates:      3,
+			olderThan:    time.Hour,
+			wantArchived: 0,
+			wantIDs:      []uint{1, 2, 3, 4},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, err := createDatabase()
+			if err != nil {
+				t.Errorf("createDatabase() error = %v", err)
+				return
+			}
+
+			err = db.AutoMigrate(Player{})
+			if err != nil {
+				t.Errorf("AutoMigrate() error = %v", err)
+				return
+			}
+
+			player := &Player{Name: "teste"}
+			err = db.Create(player).Error
+			if err != nil {
+				t.Errorf("Create() error = %v", err)
+				return
+			}
+
+			for i := 0; i < tt.updates; i++ {
+				player.Name = "teste atualizado"
+				err = db.Updates(player).Error
+				if err != nil {
+					t.Errorf("Updates() error = %v", err)
+					return
+				}
+			}
+
+			archived, err := Archive(context.Background(), db, player, tt.olderThan)
+			if err == nil && tt.twice {
+				archived, err = Archive(context.Background(), db, player, tt.olderThan)
+			}
+			if err != nil {
+				t.Errorf("Archive() error = %v", err)
+				return
+			}
+			if archived != tt.wantArchived {
+				t.Errorf("Archive() = %v, want %v", archived, tt.wantArchived)
+			}
+
+			var ids []uint
+			db.Unscoped().Model(&Player{}).Order("id").Pluck("id", &ids)
+			if !equalIDs(ids, tt.wantIDs) {
+				t.Errorf("ids = %v, want %v", ids, tt.wantIDs)
+			}
+
+			var history []Player
+			err = History(context.Background(), db, player, &history)
+			if err != nil {
+				t.Errorf("History() error = %v", err)
+				return
+			}
+
+			if len(history) != tt.updates+1 {
+				t.Errorf("History() = %v versions, want %v", len(history), tt.updates+1)
+				return
+			}
+			for i, version := range history {
+				if version.ID != uint(i+1) || (i > 0 && (version.AuditParentID == nil || *version.AuditParentID != 1)) {
+					t.Errorf("History()[%d] = %+v", i, version.AuditableModel)
+				}
+			}
+
+			err = db.Migrator().DropTable(archiveTable("players"))
+			if err != nil {
+				t.Errorf("DropTable() error = %v", err)
+				return
+			}
+			tampered, err := Verify(context.Background(), db, player)
+			if err != nil || len(tampered) > 0 {
+				t.Errorf("Verify() without archive = %v, %v, want no tampered versions", tampered, err)
+			}
+		})
+	}
+}
+
+func TestArchive_ModelWithoutAuditory(t *testing.T) {
+
+	type normalModel struct {
+		ID   uint64 `gorm:"primaryKey"`
+		Name string
+	}
+
+	db, err := createDatabase()
+	if err != nil {
+		t.Errorf("createDatabase() error = %v", err)
+		return
+	}
+
+	_, err = Archive(context.Background(), db, &normalModel{}, 0)
+	if !errors.Is(err, ErrNotAuditable) {
+		t.Errorf("Archive() error = %v, want %v", err, ErrNotAuditable)
+	}
+
+	err = History(context.Background(), db, &normalModel{}, &[]normalModel{})
+	if !errors.Is(err, ErrNotAuditable) {
+		t.Errorf("History() error = %v, want %v", err, ErrNotAuditable)
+	}
+}
+
+type archivedPlayer struct {
+	AuditableModel
+	Name string
+}
+
+func (archivedPlayer) TableName() string {
+	return "archived_players"
+}
+
+type archivedPlayerWithAddress struct {
+	AuditableModel
+	Name    string
+	Address string
+}
+
+func (archivedPlayerWithAddress) TableName() string {
+	return "archived_players"
+}
+
+func TestArchive_NewColumn(t *testing.T) {
+	db, err := createDatabase()
+	if err != nil {
+		t.Errorf("createDatabase() error = %v", err)
+		return
+	}
+
+	err = db.AutoMigrate(archivedPlayer{})
+	if err != nil {
+		t.Errorf("AutoMigrate() error = %v", err)
+		return
+	}
+
+	_, err = Archive(context.Background(), db, &archivedPlayer{}, 0)
+	if err != nil {
+		t.Errorf("Archive() error = %v", err)
+		return
+	}
+
+	err = db.AutoMigrate(archivedPlayerWithAddress{})
+	if err != nil {
+		t.Errorf("AutoMigrate() error = %v", err)
+		return
+	}
+
+	player := &archivedPlayerWithAddress{Name: "teste", Address: "teste"}
+	db.Create(player)
+	player.Address = "teste atualizado"
+	db.Updates(player)
+	player.Name = "teste atualizado"
+	db.Updates(player)
+
+	archived, err := Archive(context.Background(), db, player, 0)
+	if err != nil || archived != 1 {
+		t.Errorf("Archive() = %v, error = %v, want 1", archived, err)
+		return
+	}
+
+	var history []archivedPlayerWithAddress
+	err = History(context.Background(), db, player, &history)
+	if err != nil || len(history) != 3 || history[1].Address != "teste atualizado" {
+		t.Errorf("History() = %v, error = %v", history, err)
+	}
+}
+
+func TestArchive_FailOnDatabase(t *testing.T) {
+
+	archive := func(db *gorm.DB) error {
+		_, err := Archive(context.Background(), db, &archivedPlayer{}, 0)
+		return err
+	}
+	history := func(db *gorm.DB) error {
+		return History(context.Background(), db, &archivedPlayer{AuditableModel: AuditableModel{ID: 1}}, &[]archivedPlayer{})
+	}
+
+	tests := []struct {
+		name    string
+		prepare func(db *gorm.DB) error
+		pattern string
+		change  func(db *gorm.DB) error
+	}{
+		{
+			name:    "Fail creating the archive table",
+			pattern: "^CREATE TABLE `archived_players_archive`",
+			change:  archive,
+		},
+		{
+			name:    "Fail adding archive columns",
+			prepare: archive,
+			pattern: "^ALTER TABLE `archived_players_archive`",
+			change: func(db *gorm.DB) error {
+				if err := db.AutoMigrate(archivedPlayerWithAddress{}); err != nil {
+					return err
+				}
+				_, err := Archive(context.Background(), db, &archivedPlayerWithAddress{}, 0)
+				return err
+			},
+		},
+		{
+			name:    "Fail loading superseded versions",
+			pattern: "^SELECT `id`,`audit_parent_id`,`deleted_at` FROM `archived_players`",
+			change:  archive,
+		},
+		{
+			name:    "Fail copying versions",
+			pattern: "^INSERT INTO `archived_players_archive`",
+			change:  archive,
+		},
+		{
+			name:    "Fail loading history",
+			pattern: "^SELECT \\* FROM `archived_players` ",
+			change:  history,
+		},
+		{
+			name:    "Fail loading archived history",
+			prepare: archive,
+			pattern: "^SELECT \\* FROM `archived_players_archive`",
+			change:  history,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, err := createDatabase()
+			if err != nil {
+				t.Errorf("createDatabase() error = %v", err)
+				return
+			}
+
+			err = db.AutoMigrate(archivedPlayer{})
+			if err != nil {
+				t.Errorf("AutoMigrate() error = %v", err)
+				return
+			}
+
+			player := &archivedPlayer{Name: "teste"}
+			db.Create(player)
+			for i := 0; i < 2; i++ {
+				player.Name = "teste atualizado"
+				db.Updates(player)
+			}
+			if tt.prepare != nil {
+				if err = tt.prepare(db); err != nil {
+					t.Errorf("prepare() error = %v", err)
+					return
+				}
+			}
+
+			failOn(db, tt.pattern)
+			err = tt.change(db)
+			if !errors.Is(err, errFailingStatement) {
+				t.Errorf("change() error = %v, want %v", err, errFailingStatement)
+			}
+		})
+	}
+}
@@ -0,0 +1,454 @@
+package MegaGormAudit
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+	"reflect"
+	"strings"
+	"time"
+)
+
+const (
+	AssociationAppend  = "append"
+	AssociationReplace = "replace"
+	AssociationDelete  = "delete"
+	AssociationClear   = "clear"
+	AssociationVersion = "version" //many to many links carried over to a new version
+)
+
+const (
+	auditedAssociationKey = "megagormaudit:audited_association"
+	nativeDetachKey       = "megagormaudit:native_detach"
+)
+
+var ErrNativeAssociation = errors.New("megagormaudit: associations of auditable models must be changed with Association(db, model, name)")
+
+// AssociationChange records a change to the associations of a model, stored when the plugin LogAssociations is enabled.
+type AssociationChange struct {
+	ID            uint `gorm:"primarykey"`
+	CreatedAt     time.Time
+	OwnerTable    string
+	OwnerID       string
+	Association   string
+	Operation     string
+	AssociatedIDs string //JSON array with the primary keys of the associated models
+	Actor         string
+	Metadata      Metadata
+}
+
+func (AssociationChange) TableName() string {
+	return "audit_association_changes"
+}
+
+// AuditedAssociation changes the associations of a model like gorm.Association, recording each
+// change with its actor. Associated auditable models of has one and has many relationships are
+// versioned individually instead of being updated in place.
+type AuditedAssociation struct {
+	db    *gorm.DB
+	owner interface{}
+	name  string
+}
+
+// Association returns the audited association name of owner.
+func Association(db *gorm.DB, owner interface{}, name string) *AuditedAssociation {
+	return &AuditedAssociation{db: db, owner: owner, name: name}
+}
+
+func (a *AuditedAssociation) Append(values ...interface{}) error {
+	return a.change(AssociationAppend, values)
+}
+
+func (a *AuditedAssociation) Replace(values ...interface{}) error {
+	return a.change(AssociationReplace, values)
+}
+
+func (a *AuditedAssociation) Delete(values ...interface{}) error {
+	return a.change(AssociationDelete, values)
+}
+
+func (a *AuditedAssociation) Clear() error {
+	return a.change(AssociationClear, nil)
+}
+
+func (a *AuditedAssociation) change(operation string, values []interface{}) error {
+	db := a.db
+	if actorFromContext(db.Statement.Context) == "" {
+		db = db.WithContext(WithActor(db.Statement.Context, actorOf(a.owner)))
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		association := tx.Set(auditedAssociationKey, true).Model(a.owner).Association(a.name)
+		if association.Error != nil {
+			return association.Error
+		}
+
+		rel := association.Relationship
+		var err error
+		if (rel.Type == schema.HasOne || rel.Type == schema.HasMany) && isAuditableSchema(rel.FieldSchema) {
+			err = versionAssociation(tx, rel, a.owner, operation, values)
+		} else {
+			switch operation {
+			case AssociationAppend:
+				err = association.Append(values...)
+			case AssociationReplace:
+				err = association.Replace(values...)
+			case AssociationDelete:
+				err = association.Delete(values...)
+			default:
+				err = association.Clear()
+			}
+		}
+		if err != nil {
+			return err
+		}
+
+		if !pluginOf(tx).LogAssociations {
+			return nil
+		}
+
+		ids := []interface{}{}
+		for _, value := range associationValues(values) {
+			id, _ := rel.FieldSchema.PrioritizedPrimaryField.ValueOf(tx.Statement.Context, value)
+			ids = append(ids, id)
+		}
+		ownerID, _ := rel.Schema.PrioritizedPrimaryField.ValueOf(tx.Statement.Context, reflect.Indirect(reflect.ValueOf(a.owner)))
+		return recordAssociationChange(tx, rel, fmt.Sprint(ownerID), operation, ids, actorOf(a.owner))
+	})
+}
+
+// versionAssociation changes a has one or has many association with auditable models
+// by creating new versions of the associated models with the foreign key changed.
+func versionAssociation(tx *gorm.DB, rel *schema.Relationship, owner interface{}, operation string, values []interface{}) error {
+	ref := ownReference(rel)
+
+	ctx := tx.Statement.Context
+	ownerID, _ := ref.PrimaryKey.ValueOf(ctx, reflect.Indirect(reflect.ValueOf(owner)))
+	associated := associationValues(values)
+	for _, value := range associated {
+		if value.Kind() != reflect.Struct || !value.CanAddr() {
+			return gorm.ErrInvalidValue
+		}
+	}
+
+	if operation == AssociationReplace || operation == AssociationClear || (rel.Type == schema.HasOne && operation == AssociationAppend) {
+		keep := map[interface{}]bool{}
+		for _, value := range associated {
+			id, _ := rel.FieldSchema.PrioritizedPrimaryField.ValueOf(ctx, value)
+			keep[id] = true
+		}
+
+		current := reflect.New(reflect.SliceOf(rel.FieldSchema.ModelType))
+		err := tx.Session(&gorm.Session{NewDB: true}).Where(clause.Eq{Column: clause.Column{Name: ref.ForeignKey.DBName}, Value: ownerID}).Find(current.Interface()).Error
+		if err != nil {
+			return err
+		}
+
+		for i := 0; i < current.Elem().Len(); i++ {
+			child := current.Elem().Index(i)
+			if id, _ := rel.FieldSchema.PrioritizedPrimaryField.ValueOf(ctx, child); !keep[id] {
+				if err := saveAssociated(tx, ref, child, reflect.Zero(ref.ForeignKey.FieldType).Interface()); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	for _, value := range associated {
+		foreignKey := ownerID
+		if operation == AssociationDelete {
+			foreignKey = reflect.Zero(ref.ForeignKey.FieldType).Interface()
+		}
+		if err := saveAssociated(tx, ref, value, foreignKey); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ownReference returns the reference of a has one or has many relationship holding the owner primary key.
+func ownReference(rel *schema.Relationship) (ref *schema.Reference) {
+	for _, r := range rel.References {
+		if r.OwnPrimaryKey {
+			ref = r
+		}
+	}
+	return ref
+}
+
+// saveAssociated sets the foreign key of an associated auditable model and saves it as a new version,
+// or creates it when it's a new model.
+func saveAssociated(tx *gorm.DB, ref *schema.Reference, value reflect.Value, foreignKey interface{}) error {
+	if err := ref.ForeignKey.Set(tx.Statement.Context, value, foreignKey); err != nil {
+		return err
+	}
+
+	session := tx.Session(&gorm.Session{NewDB: true})
+	if auditableModelOf(value.Addr().Interface()).ID == 0 {
+		return session.Create(value.Addr().Interface()).Error
+	}
+	return session.Updates(value.Addr().Interface()).Error
+}
+
+// associationValues returns the structs of values, expanding slices.
+func associationValues(values []interface{}) []reflect.Value {
+	var result []reflect.Value
+	for _, value := range values {
+		rv := reflect.ValueOf(value)
+		if rv.Kind() == reflect.Ptr {
+			rv = rv.Elem()
+		}
+
+		if rv.Kind() == reflect.Slice || rv.Kind() == reflect.Array {
+			for i := 0; i < rv.Len(); i++ {
+				result = append(result, reflect.Indirect(rv.Index(i)))
+			}
+		} else {
+			result = append(result, rv)
+		}
+	}
+	return result
+}
+
+// auditableChildren returns the has one and has many relationships of the schema with auditable models.
+func auditableChildren(auditSchema *schema.Schema) []*schema.Relationship {
+	var rels []*schema.Relationship
+	for _, rel := range append(auditSchema.Relationships.HasOne, auditSchema.Relationships.HasMany...) {
+		if rel.Name != "AuditParent" && isAuditableSchema(rel.FieldSchema) {
+			rels = append(rels, rel)
+		}
+	}
+	return rels
+}
+
+// createChildren creates the new auditable children loaded in model pointing at the version newID.
+// Children already stored are left to the ForeignKeys policy instead of being updated in place.
+func createChildren(tx *gorm.DB, rels []*schema.Relationship, model interface{}, newID uint) error {
+	ctx := tx.Statement.Context
+	for _, rel := range rels {
+		ref := ownReference(rel)
+		field := rel.Field.ReflectValueOf(ctx, reflect.Indirect(reflect.ValueOf(model)))
+		for _, child := range associationValues([]interface{}{field.Addr().Interface()}) {
+			if child.IsValid() && auditableModelOf(child.Addr().Interface()).ID == 0 {
+				if err := saveAssociated(tx, ref, child, newID); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// associationsOnly reports whether the update only saves associations, as gorm.Association does.
+func associationsOnly(stmt *gorm.Statement) bool {
+	if len(stmt.Selects) == 0 {
+		return false
+	}
+	for _, name := range stmt.Selects {
+		if _, ok := stmt.Schema.Relationships.Relations[name]; !ok && stmt.Schema.LookUpField(name) != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// guardAssociation versions the auditable children changed with gorm.Association on auditable models, as Association does,
+// or rejects the change when the plugin Protection isn't off. Many to many links of join tables configured in JoinTables
+// are recorded, so they can always be changed with gorm.Association.
+func (a MegaGormAuditPlugin) guardAssociation(db *gorm.DB) {
+	if db.Error != nil || !a.nativeAssociation(db.Statement) {
+		return
+	}
+	if a.Protection != ProtectionOff {
+		db.AddError(ErrNativeAssociation)
+		return
+	}
+
+	stmt := db.Statement
+	if !isAuditableSchema(stmt.Schema) {
+		return
+	}
+	if !associationsOnly(stmt) {
+		//the foreign keys unset by Delete, Clear and Replace are versioned by guardUpdate
+		stmt.Settings.Store(nativeDetachKey, true)
+		return
+	}
+
+	owner := reflect.Indirect(reflect.ValueOf(stmt.Model))
+	tx := db.Session(&gorm.Session{NewDB: true})
+	if actorFromContext(stmt.Context) == "" {
+		tx = tx.WithContext(WithActor(stmt.Context, actorOf(owner.Interface())))
+	}
+	err := tx.Transaction(func(tx *gorm.DB) error {
+		for _, name := range stmt.Selects {
+			rel := stmt.Schema.Relationships.Relations[name]
+			if rel == nil || rel.JoinTable != nil || !isAuditableSchema(rel.FieldSchema) {
+				continue
+			}
+
+			if err := a.versionAppended(tx, rel, owner); err != nil {
+				return err
+			}
+			stmt.Omits = append(stmt.Omits, name)
+		}
+		return nil
+	})
+	db.AddError(err)
+}
+
+// versionAppended saves the auditable children of rel added to owner by gorm.Association Append and Replace
+// as new versions pointing at owner, recording them with LogAssociations.
+func (a MegaGormAuditPlugin) versionAppended(tx *gorm.DB, rel *schema.Relationship, owner reflect.Value) error {
+	ref := ownReference(rel)
+	ctx := tx.Statement.Context
+	ownerID, _ := ref.PrimaryKey.ValueOf(ctx, owner)
+
+	ids := []interface{}{}
+	field := rel.Field.ReflectValueOf(ctx, owner)
+	for _, child := range associationValues([]interface{}{field.Addr().Interface()}) {
+		child = reflect.Indirect(child) //has one fields can be pointers
+		if foreignKey, _ := ref.ForeignKey.ValueOf(ctx, child); foreignKey == ownerID && auditableModelOf(child.Addr().Interface()).ID != 0 {
+			continue
+		}
+
+		if err := saveAssociated(tx, ref, child, ownerID); err != nil {
+			return err
+		}
+		id, _ := rel.FieldSchema.PrioritizedPrimaryField.ValueOf(ctx, child)
+		ids = append(ids, id)
+	}
+
+	if !a.LogAssociations || len(ids) == 0 {
+		return nil
+	}
+	return recordAssociationChange(tx, rel, fmt.Sprint(ownerID), AssociationAppend, ids, actorOf(owner.Interface()))
+}
+
+// nativeAssociation reports whether the statement is issued by gorm.Association on an auditable model:
+// the owner update of Append and Replace, the foreign keys unset by has one and has many Delete and Clear,
+// or the join rows removed by many to many Delete, Replace and Clear.
+func (a MegaGormAuditPlugin) nativeAssociation(stmt *gorm.Statement) bool {
+	if stmt.Schema == nil {
+		return false
+	}
+	if _, audited := stmt.Settings.Load(auditedAssociationKey); audited {
+		return false
+	}
+
+	if isAuditableSchema(stmt.Schema) {
+		if associationsOnly(stmt) {
+			for _, name := range stmt.Selects {
+				rel, ok := stmt.Schema.Relationships.Relations[name]
+				if ok && (rel.JoinTable == nil || !a.isJoinTable(rel.JoinTable.Table)) {
+					return true
+				}
+			}
+			return false
+		}
+
+		values, ok := stmt.Dest.(map[string]interface{})
+		model := reflect.Indirect(reflect.ValueOf(stmt.Model))
+		if !ok || len(values) == 0 || model.Kind() != reflect.Struct || auditableModelOf(model.Interface()).ID != 0 {
+			return false
+		}
+		for _, value := range values {
+			if value != nil {
+				return false
+			}
+		}
+		return true
+	}
+
+	if stmt.Schema.ModelType.Name() == "" && !a.isJoinTable(stmt.Schema.Table) {
+		for _, rel := range stmt.Schema.Relationships.Relations {
+			if isAuditableSchema(rel.FieldSchema) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// carryJoinRows copies the many to many links of the version oldID to the version newID.
+func (a MegaGormAuditPlugin) carryJoinRows(tx *gorm.DB, auditSchema *schema.Schema, oldID, newID uint, actor string) error {
+	for _, rel := range auditSchema.Relationships.Many2Many {
+		var ownerColumn, associatedColumn string
+		for _, ref := range rel.References {
+			if ref.OwnPrimaryKey {
+				ownerColumn = ref.ForeignKey.DBName
+			} else {
+				associatedColumn = ref.ForeignKey.DBName
+			}
+		}
+
+		var ids []interface{}
+		err := tx.Session(&gorm.Session{NewDB: true}).Table(rel.JoinTable.Table).
+			Where(clause.Eq{Column: clause.Column{Name: ownerColumn}, Value: oldID}).
+			Pluck(associatedColumn, &ids).Error
+		if err != nil {
+			return err
+		}
+		if len(ids) == 0 {
+			continue
+		}
+
+		columns := make([]string, 0, len(rel.JoinTable.DBNames))
+		values := make([]string, 0, len(rel.JoinTable.DBNames))
+		for _, name := range rel.JoinTable.DBNames {
+			columns = append(columns, tx.Statement.Quote(name))
+			if name == ownerColumn {
+				values = append(values, "?")
+			} else {
+				values = append(values, tx.Statement.Quote(name))
+			}
+		}
+
+		err = tx.Exec("INSERT INTO ? ("+strings.Join(columns, ",")+") SELECT "+strings.Join(values, ",")+" FROM ? WHERE ? = ?",
+			clause.Table{Name: rel.JoinTable.Table}, newID, clause.Table{Name: rel.JoinTable.Table}, clause.Column{Name: ownerColumn}, oldID).Error
+		if err != nil {
+			return err
+		}
+
+		if a.LogAssociations {
+			if err := recordAssociationChange(tx, rel, fmt.Sprint(newID), AssociationVersion, ids, actor); err != nil {
+				return err
+			}
+		}
+
+		if a.isJoinTable(rel.JoinTable.Table) {
+			for _, id := range ids {
+				keys := map[string]interface{}{ownerColumn: newID, associatedColumn: id}
+				if err := recordJoinTableChange(tx, rel.JoinTable.Table, JoinTableInsert, keys, actor); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func recordAssociationChange(tx *gorm.DB, rel *schema.Relationship, ownerID, operation string, ids []interface{}, actor string) error {
+	associatedIDs, _ := json.Marshal(ids) //primary key values are always encoded
+	return tx.Session(&gorm.Session{NewDB: true}).Create(&AssociationChange{
+		OwnerTable:    rel.Schema.Table,
+		OwnerID:       ownerID,
+		Association:   rel.Name,
+		Operation:     operation,
+		AssociatedIDs: string(associatedIDs),
+		Actor:         actor,
+		Metadata:      pluginOf(tx).contextMetadata(tx.Statement.Context),
+	}).Error
+}
+
+// actorOf returns the user responsible for changing model.
+func actorOf(model interface{}) string {
+	if value := reflect.Indirect(reflect.ValueOf(model)); value.Kind() == reflect.Struct {
+		if auditable := value.FieldByName("AuditableModel"); auditable.IsValid() {
+			return auditable.Interface().(AuditableModel).LastChangedUser
+		}
+	}
+	return ""
+}
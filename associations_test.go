@@ -0,0 +1,638 @@
+package MegaGormAudit
+
+import (
+	"context"
+	"errors"
+	"gorm.io/gorm"
+	"reflect"
+	"testing"
+)
+
+type Role struct {
+	ID   uint
+	Name string
+}
+
+type Device struct {
+	AuditableModel
+	UserID uint
+	Name   string
+}
+
+type User struct {
+	AuditableModel
+	Name    string
+	Roles   []Role `gorm:"many2many:user_roles"`
+	Devices []Device
+}
+
+func TestAuditPlugin_Associations(t *testing.T) {
+
+	unchangedDevices := func(db *gorm.DB) bool {
+		var devices []Device
+		db.Unscoped().Find(&devices)
+		return len(devices) == 1 && devices[0].UserID == 1 && devices[0].DeletedAt == 0
+	}
+	unchangedUser := func(db *gorm.DB) bool {
+		return countRows(db, "users") == 1 && countRows(db, "user_roles") == 1 && unchangedDevices(db)
+	}
+
+	tests := []struct {
+		name        string
+		protection  ProtectionMode
+		pattern     string
+		change      func(db *gorm.DB, user *User) error
+		wantErr     error
+		wantChanges []AssociationChange
+		successTest func(db *gorm.DB) bool
+	}{
+		{
+			name: "Success, update carries many to many links",
+			change: func(db *gorm.DB, user *User) error {
+				user.Name = "updated user"
+				return db.Updates(user).Error
+			},
+			wantChanges: []AssociationChange{
+				{OwnerTable: "users", OwnerID: "2", Association: "Roles", Operation: AssociationVersion, AssociatedIDs: "[1]", Actor: "admin"},
+			},
+			successTest: func(db *gorm.DB) bool {
+				var users []User
+				var roles []Role
+				var devices []Device
+				db.Preload("Roles").Find(&users)
+				db.Find(&roles)
+				db.Find(&devices)
+				return len(users) == 1 && users[0].ID == 2 && len(users[0].Roles) == 1 &&
+					len(roles) == 1 && len(devices) == 1 && devices[0].UserID == 1 && countRows(db, "user_roles") == 2
+			},
+		},
+		{
+			name: "Success, update creates new auditable children",
+			change: func(db *gorm.DB, user *User) error {
+				user.Devices = append(user.Devices, Device{Name: "tablet"})
+				return db.Updates(user).Error
+			},
+			wantChanges: []AssociationChange{
+				{OwnerTable: "users", OwnerID: "2", Association: "Roles", Operation: AssociationVersion, AssociatedIDs: "[1]", Actor: "admin"},
+			},
+			successTest: func(db *gorm.DB) bool {
+				var devices []Device
+				db.Unscoped().Order("id").Find(&devices)
+				return len(devices) == 2 && devices[0].UserID == 1 && devices[0].DeletedAt == 0 &&
+					devices[1].UserID == 2 && devices[1].Name == "tablet"
+			},
+		},
+		{
+			name: "Success, append many to many",
+			change: func(db *gorm.DB, user *User) error {
+				return Association(db, user, "Roles").Append(&Role{Name: "editor"})
+			},
+			wantChanges: []AssociationChange{
+				{OwnerTable: "users", OwnerID: "1", Association: "Roles", Operation: AssociationAppend, AssociatedIDs: "[2]", Actor: "admin"},
+			},
+			successTest: func(db *gorm.DB) bool {
+				var users []User
+				db.Unscoped().Preload("Roles").Find(&users)
+				return len(users) == 1 && len(users[0].Roles) == 2
+			},
+		},
+		{
+			name: "Success, delete many to many",
+			change: func(db *gorm.DB, user *User) error {
+				return Association(db, user, "Roles").Delete(&user.Roles[0])
+			},
+			wantChanges: []AssociationChange{
+				{OwnerTable: "users", OwnerID: "1", Association: "Roles", Operation: AssociationDelete, AssociatedIDs: "[1]", Actor: "admin"},
+			},
+			successTest: func(db *gorm.DB) bool {
+				return countRows(db, "user_roles") == 0 && countRows(db, "roles") == 1
+			},
+		},
+		{
+			name: "Success, replace many to many",
+			change: func(db *gorm.DB, user *User) error {
+				return Association(db, user, "Roles").Replace([]Role{{Name: "editor"}, {Name: "viewer"}})
+			},
+			wantChanges: []AssociationChange{
+				{OwnerTable: "users", OwnerID: "1", Association: "Roles", Operation: AssociationReplace, AssociatedIDs: "[2,3]", Actor: "admin"},
+			},
+			successTest: func(db *gorm.DB) bool {
+				return countRows(db, "user_roles") == 2 && countRows(db, "roles") == 3
+			},
+		},
+		{
+			name: "Success, clear many to many",
+			change: func(db *gorm.DB, user *User) error {
+				return Association(db, user, "Roles").Clear()
+			},
+			wantChanges: []AssociationChange{
+				{OwnerTable: "users", OwnerID: "1", Association: "Roles", Operation: AssociationClear, AssociatedIDs: "[]", Actor: "admin"},
+			},
+			successTest: func(db *gorm.DB) bool {
+				return countRows(db, "user_roles") == 0
+			},
+		},
+		{
+			name: "Success, append auditable has many",
+			change: func(db *gorm.DB, user *User) error {
+				return Association(db, user, "Devices").Append(&Device{Name: "tablet"})
+			},
+			wantChanges: []AssociationChange{
+				{OwnerTable: "users", OwnerID: "1", Association: "Devices", Operation: AssociationAppend, AssociatedIDs: "[2]", Actor: "admin"},
+			},
+			successTest: func(db *gorm.DB) bool {
+				var devices []Device
+				db.Where("user_id = ?", 1).Find(&devices)
+				return len(devices) == 2 && countRows(db, "users") == 1
+			},
+		},
+		{
+			name: "Success, delete auditable has many",
+			change: func(db *gorm.DB, user *User) error {
+				return Association(db, user, "Devices").Delete(user.Devices)
+			},
+			wantChanges: []AssociationChange{
+				{OwnerTable: "users", OwnerID: "1", Association: "Devices", Operation: AssociationDelete, AssociatedIDs: "[2]", Actor: "admin"},
+			},
+			successTest: func(db *gorm.DB) bool {
+				var devices []Device
+				db.Unscoped().Order("id").Find(&devices)
+				return len(devices) == 2 && devices[0].UserID == 1 && devices[0].DeletedAt > 0 &&
+					devices[1].UserID == 0 && devices[1].DeletedAt == 0
+			},
+		},
+		{
+			name: "Success, replace auditable has many",
+			change: func(db *gorm.DB, user *User) error {
+				return Association(db, user, "Devices").Replace(&Device{Name: "tablet"})
+			},
+			wantChanges: []AssociationChange{
+				{OwnerTable: "users", OwnerID: "1", Association: "Devices", Operation: AssociationReplace, AssociatedIDs: "[3]", Actor: "admin"},
+			},
+			successTest: func(db *gorm.DB) bool {
+				var devices []Device
+				db.Order("id").Find(&devices)
+				return len(devices) == 2 && devices[0].ID == 2 && devices[0].UserID == 0 &&
+					devices[1].ID == 3 && devices[1].UserID == 1
+			},
+		},
+		{
+			name: "Fail on auditable has many value that can't be versioned",
+			change: func(db *gorm.DB, user *User) error {
+				return Association(db, user, "Devices").Append(Device{Name: "tablet"})
+			},
+			wantErr: gorm.ErrInvalidValue,
+			successTest: func(db *gorm.DB) bool {
+				return countRows(db, "devices") == 1
+			},
+		},
+		{
+			name: "Success, native append to auditable has many versioned",
+			change: func(db *gorm.DB, user *User) error {
+				return db.Model(user).Association("Devices").Append(&Device{Name: "tablet"})
+			},
+			wantChanges: []AssociationChange{
+				{OwnerTable: "users", OwnerID: "1", Association: "Devices", Operation: AssociationAppend, AssociatedIDs: "[2]", Actor: "admin"},
+			},
+			successTest: func(db *gorm.DB) bool {
+				var devices []Device
+				db.Unscoped().Order("id").Find(&devices)
+				return len(devices) == 2 && devices[0].UserID == 1 && devices[0].DeletedAt == 0 &&
+					devices[1].UserID == 1 && devices[1].Name == "tablet" && devices[1].LastChangedUser == "admin"
+			},
+		},
+		{
+			name: "Success, native append of a device of another user versioned",
+			change: func(db *gorm.DB, user *User) error {
+				other := &User{Name: "other user", Devices: []Device{{Name: "tablet"}}}
+				if err := db.Create(other).Error; err != nil {
+					return err
+				}
+				return db.Model(user).Association("Devices").Append(&other.Devices[0])
+			},
+			wantChanges: []AssociationChange{
+				{OwnerTable: "users", OwnerID: "1", Association: "Devices", Operation: AssociationAppend, AssociatedIDs: "[3]", Actor: "admin"},
+			},
+			successTest: func(db *gorm.DB) bool {
+				var devices []Device
+				db.Unscoped().Order("id").Find(&devices)
+				return len(devices) == 3 && devices[1].UserID == 2 && devices[1].DeletedAt != 0 &&
+					devices[2].UserID == 1 && *devices[2].AuditParentID == 2 && devices[2].DeletedAt == 0
+			},
+		},
+		{
+			name: "Success, native append of an attached device unchanged",
+			change: func(db *gorm.DB, user *User) error {
+				return db.Model(user).Association("Devices").Append(&user.Devices[0])
+			},
+			successTest: unchangedUser,
+		},
+		{
+			name: "Success, native delete of many to many of auditable model",
+			change: func(db *gorm.DB, user *User) error {
+				return db.Model(user).Association("Roles").Delete(&user.Roles[0])
+			},
+			successTest: func(db *gorm.DB) bool {
+				return countRows(db, "user_roles") == 0 && countRows(db, "roles") == 1
+			},
+		},
+		{
+			name: "Success, native delete of auditable has many versioned",
+			change: func(db *gorm.DB, user *User) error {
+				return db.WithContext(WithActor(context.Background(), "remover")).Model(user).Association("Devices").Delete(user.Devices)
+			},
+			successTest: func(db *gorm.DB) bool {
+				var devices []Device
+				db.Unscoped().Order("id").Find(&devices)
+				return len(devices) == 2 && devices[0].UserID == 1 && devices[0].DeletedAt != 0 &&
+					devices[1].UserID == 0 && devices[1].DeletedAt == 0 && devices[1].LastChangedUser == "remover"
+			},
+		},
+		{
+			name: "Success, native replace of auditable has many versioned",
+			change: func(db *gorm.DB, user *User) error {
+				return db.Model(user).Association("Devices").Replace(&Device{Name: "tablet"})
+			},
+			wantChanges: []AssociationChange{
+				{OwnerTable: "users", OwnerID: "1", Association: "Devices", Operation: AssociationAppend, AssociatedIDs: "[2]", Actor: "admin"},
+			},
+			successTest: func(db *gorm.DB) bool {
+				var devices []Device
+				db.Order("id").Find(&devices)
+				return len(devices) == 2 && devices[0].Name == "tablet" && devices[0].UserID == 1 &&
+					devices[1].Name == "phone" && devices[1].UserID == 0 && countRows(db, "devices") == 3
+			},
+		},
+		{
+			name: "Success, native append to many to many of auditable model",
+			change: func(db *gorm.DB, user *User) error {
+				return db.Model(user).Association("Roles").Append(&Role{Name: "editor"})
+			},
+			successTest: func(db *gorm.DB) bool {
+				return countRows(db, "user_roles") == 2 && countRows(db, "roles") == 2 && countRows(db, "users") == 1
+			},
+		},
+		{
+			name:    "Fail versioning native append to auditable has many",
+			pattern: "^INSERT INTO `devices`",
+			change: func(db *gorm.DB, user *User) error {
+				return db.Model(user).Association("Devices").Append(&Device{Name: "tablet"})
+			},
+			wantErr:     errFailingStatement,
+			successTest: unchangedUser,
+		},
+		{
+			name:    "Fail recording native append to auditable has many",
+			pattern: "^INSERT INTO `audit_association_changes`",
+			change: func(db *gorm.DB, user *User) error {
+				return db.Model(user).Association("Devices").Append(&Device{Name: "tablet"})
+			},
+			wantErr:     errFailingStatement,
+			successTest: unchangedUser,
+		},
+		{
+			name:       "Fail on native append to auditable has many with protection",
+			protection: ProtectionReject,
+			change: func(db *gorm.DB, user *User) error {
+				return db.Model(user).Association("Devices").Append(&Device{Name: "tablet"})
+			},
+			wantErr: ErrNativeAssociation,
+			successTest: func(db *gorm.DB) bool {
+				return countRows(db, "devices") == 1
+			},
+		},
+		{
+			name:       "Fail on native delete of auditable has many with protection",
+			protection: ProtectionReject,
+			change: func(db *gorm.DB, user *User) error {
+				return db.Model(user).Association("Devices").Delete(user.Devices)
+			},
+			wantErr: ErrNativeAssociation,
+			successTest: func(db *gorm.DB) bool {
+				var devices []Device
+				db.Find(&devices)
+				return len(devices) == 1 && devices[0].UserID == 1
+			},
+		},
+		{
+			name:       "Fail on native clear of auditable has many with protection",
+			protection: ProtectionReject,
+			change: func(db *gorm.DB, user *User) error {
+				return db.Model(user).Association("Devices").Clear()
+			},
+			wantErr: ErrNativeAssociation,
+			successTest: func(db *gorm.DB) bool {
+				var devices []Device
+				db.Find(&devices)
+				return len(devices) == 1 && devices[0].UserID == 1
+			},
+		},
+		{
+			name:       "Fail on native append to many to many of auditable model with protection",
+			protection: ProtectionReject,
+			change: func(db *gorm.DB, user *User) error {
+				return db.Model(user).Association("Roles").Append(&Role{Name: "editor"})
+			},
+			wantErr: ErrNativeAssociation,
+			successTest: func(db *gorm.DB) bool {
+				return countRows(db, "user_roles") == 1 && countRows(db, "roles") == 1
+			},
+		},
+		{
+			name:       "Fail on native delete of many to many of auditable model with protection",
+			protection: ProtectionReject,
+			change: func(db *gorm.DB, user *User) error {
+				return db.Model(user).Association("Roles").Delete(&user.Roles[0])
+			},
+			wantErr: ErrNativeAssociation,
+			successTest: func(db *gorm.DB) bool {
+				return countRows(db, "user_roles") == 1
+			},
+		},
+		{
+			name: "Success, update without many to many links",
+			change: func(db *gorm.DB, user *User) error {
+				other := &User{Name: "other user"}
+				if err := db.Create(other).Error; err != nil {
+					return err
+				}
+				other.Name = "other user updated"
+				return db.Updates(other).Error
+			},
+			successTest: func(db *gorm.DB) bool {
+				return countRows(db, "users") == 3 && countRows(db, "user_roles") == 1
+			},
+		},
+		{
+			name:    "Fail loading current auditable has many",
+			pattern: "^SELECT \\* FROM `devices` WHERE `user_id`",
+			change: func(db *gorm.DB, user *User) error {
+				return Association(db, user, "Devices").Replace(&Device{Name: "tablet"})
+			},
+			wantErr:     errFailingStatement,
+			successTest: unchangedDevices,
+		},
+		{
+			name:    "Fail detaching auditable has many",
+			pattern: "^UPDATE `devices` SET",
+			change: func(db *gorm.DB, user *User) error {
+				return Association(db, user, "Devices").Replace(&Device{Name: "tablet"})
+			},
+			wantErr:     errFailingStatement,
+			successTest: unchangedDevices,
+		},
+		{
+			name:    "Fail versioning auditable has many",
+			pattern: "^UPDATE `devices` SET",
+			change: func(db *gorm.DB, user *User) error {
+				return Association(db, user, "Devices").Delete(user.Devices)
+			},
+			wantErr:     errFailingStatement,
+			successTest: unchangedDevices,
+		},
+		{
+			name:    "Fail loading many to many links",
+			pattern: "^SELECT `role_id` FROM `user_roles`",
+			change: func(db *gorm.DB, user *User) error {
+				user.Name = "updated user"
+				return db.Updates(user).Error
+			},
+			wantErr:     errFailingStatement,
+			successTest: unchangedUser,
+		},
+		{
+			name:    "Fail carrying many to many links",
+			pattern: "^INSERT INTO `user_roles`",
+			change: func(db *gorm.DB, user *User) error {
+				user.Name = "updated user"
+				return db.Updates(user).Error
+			},
+			wantErr:     errFailingStatement,
+			successTest: unchangedUser,
+		},
+		{
+			name:    "Fail recording carried many to many links",
+			pattern: "^INSERT INTO `audit_association_changes`",
+			change: func(db *gorm.DB, user *User) error {
+				user.Name = "updated user"
+				return db.Updates(user).Error
+			},
+			wantErr:     errFailingStatement,
+			successTest: unchangedUser,
+		},
+		{
+			name:    "Fail creating new auditable children",
+			pattern: "^INSERT INTO `devices`",
+			change: func(db *gorm.DB, user *User) error {
+				user.Devices = append(user.Devices, Device{Name: "tablet"})
+				return db.Updates(user).Error
+			},
+			wantErr:     errFailingStatement,
+			successTest: unchangedUser,
+		},
+		{
+			name: "Fail on unknown association",
+			change: func(db *gorm.DB, user *User) error {
+				return Association(db, user, "Unknown").Clear()
+			},
+			wantErr: gorm.ErrUnsupportedRelation,
+			successTest: func(db *gorm.DB) bool {
+				return countRows(db, "audit_association_changes") == 0
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, err := createDatabaseWithPlugin(MegaGormAuditPlugin{LogAssociations: true, Protection: tt.protection})
+			if err != nil {
+				t.Errorf("createDatabase() error = %v", err)
+				return
+			}
+
+			err = db.AutoMigrate(User{}, Role{}, Device{}, AssociationChange{})
+			if err != nil {
+				t.Errorf("AutoMigrate() error = %v", err)
+				return
+			}
+
+			user := &User{
+				AuditableModel: AuditableModel{LastChangedUser: "admin"},
+				Name:           "user",
+				Roles:          []Role{{Name: "admin"}},
+				Devices:        []Device{{Name: "phone"}},
+			}
+			err = db.Create(user).Error
+			if err != nil {
+				t.Errorf("Create() error = %v", err)
+				return
+			}
+
+			if tt.pattern != "" {
+				failOn(db, tt.pattern)
+			}
+			err = tt.change(db, user)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("change() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+
+			var changes []AssociationChange
+			db.Order("id").Find(&changes)
+			if len(changes) != len(tt.wantChanges) {
+				t.Errorf("AssociationChange = %v, want %v", changes, tt.wantChanges)
+				return
+			}
+			for i, change := range changes {
+				change.ID, change.CreatedAt = 0, tt.wantChanges[i].CreatedAt
+				if !reflect.DeepEqual(change, tt.wantChanges[i]) {
+					t.Errorf("AssociationChange = %v, want %v", change, tt.wantChanges[i])
+				}
+			}
+
+			if !tt.successTest(db) {
+				t.Errorf("successTest() = false, want true")
+			}
+		})
+	}
+}
+
+func TestAuditPlugin_HasOneAssociation(t *testing.T) {
+
+	type Passport struct {
+		AuditableModel
+		TravelerID uint
+		Number     string
+	}
+	type Traveler struct {
+		AuditableModel
+		Name     string
+		Passport *Passport
+	}
+
+	tests := []struct {
+		name        string
+		change      func(db *gorm.DB, traveler *Traveler, other *Passport) error
+		wantNumbers []string
+	}{
+		{
+			name: "Success, append detaches the current model",
+			change: func(db *gorm.DB, traveler *Traveler, other *Passport) error {
+				return Association(db, traveler, "Passport").Append(&Passport{Number: "new"})
+			},
+			wantNumbers: []string{"new"},
+		},
+		{
+			name: "Success, native append detaches the current model",
+			change: func(db *gorm.DB, traveler *Traveler, other *Passport) error {
+				return db.Model(traveler).Association("Passport").Append(&Passport{Number: "new"})
+			},
+			wantNumbers: []string{"new"},
+		},
+		{
+			name: "Success, native append of another model",
+			change: func(db *gorm.DB, traveler *Traveler, other *Passport) error {
+				return db.Model(traveler).Association("Passport").Append(other)
+			},
+			wantNumbers: []string{"other"},
+		},
+		{
+			name: "Success, delete keeps the current model",
+			change: func(db *gorm.DB, traveler *Traveler, other *Passport) error {
+				return Association(db, traveler, "Passport").Delete(other)
+			},
+			wantNumbers: []string{"current"},
+		},
+		{
+			name: "Success, delete detaches the deleted model",
+			change: func(db *gorm.DB, traveler *Traveler, other *Passport) error {
+				return Association(db, traveler, "Passport").Delete(traveler.Passport)
+			},
+			wantNumbers: []string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, err := createDatabase()
+			if err != nil {
+				t.Errorf("createDatabase() error = %v", err)
+				return
+			}
+
+			err = db.AutoMigrate(Traveler{}, Passport{})
+			if err != nil {
+				t.Errorf("AutoMigrate() error = %v", err)
+				return
+			}
+
+			traveler := &Traveler{Name: "traveler", Passport: &Passport{Number: "current"}}
+			other := &Passport{Number: "other"}
+			if err = db.Create(traveler).Error; err != nil {
+				t.Errorf("Create() error = %v", err)
+				return
+			}
+			if err = db.Create(other).Error; err != nil {
+				t.Errorf("Create() error = %v", err)
+				return
+			}
+
+			err = tt.change(db, traveler, other)
+			if err != nil {
+				t.Errorf("change() error = %v", err)
+				return
+			}
+
+			var numbers []string
+			db.Model(&Passport{}).Where("traveler_id = ?", traveler.ID).Order("id").Pluck("number", &numbers)
+			if !reflect.DeepEqual(numbers, tt.wantNumbers) {
+				t.Errorf("numbers = %v, want %v", numbers, tt.wantNumbers)
+			}
+		})
+	}
+}
+
+func countRows(db *gorm.DB, table string) int64 {
+	var count int64
+	db.Table(table).Where("1 = 1").Count(&count)
+	return count
+}
+
+func TestAuditPlugin_AssociationInvalidForeignKey(t *testing.T) {
+
+	type Badge struct {
+		AuditableModel
+		OwnerID bool
+		Name    string
+	}
+	type Owner struct {
+		AuditableModel
+		Name   string
+		Badges []Badge
+	}
+
+	db, err := createDatabase()
+	if err != nil {
+		t.Errorf("createDatabase() error = %v", err)
+		return
+	}
+
+	err = db.AutoMigrate(Owner{}, Badge{})
+	if err != nil {
+		t.Errorf("AutoMigrate() error = %v", err)
+		return
+	}
+
+	owner := &Owner{Name: "owner"}
+	err = db.Create(owner).Error
+	if err != nil {
+		t.Errorf("Create() error = %v", err)
+		return
+	}
+
+	err = Association(db, owner, "Badges").Append(&Badge{Name: "badge"})
+	if err == nil {
+		t.Errorf("Append() error = nil, want error")
+	}
+	if count := countRows(db, "badges"); count != 0 {
+		t.Errorf("badges = %d, want 0", count)
+	}
+}
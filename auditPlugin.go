@@ -1,52 +1,246 @@
 package MegaGormAudit
 
 import (
+	"errors"
 	"gorm.io/gorm"
 	"gorm.io/gorm/callbacks"
 	"gorm.io/gorm/clause"
+	"log/slog"
 	"reflect"
+	"time"
 )
 
-type MegaGormAuditPlugin struct{}
+type MegaGormAuditPlugin struct {
+	Hooks             []interface{}    //global hooks implementing BeforeAuditVersionInterface and/or AfterAuditVersionInterface
+	ForeignKeys       ForeignKeyPolicy //what happens to live rows referencing a superseded version
+	ReferencingModels []interface{}    //models with belongs to relationships to auditable models
+	Models            []interface{}    //auditable models whose tables are protected from the start
+	LogAssociations   bool             //records association changes in AssociationChange
+	JoinTables        []string         //join tables whose inserts and deletes are recorded in JoinTableChange
+	Protection        ProtectionMode   //what happens to updates of auditable tables that would skip versioning
+	Encrypter         Encrypter        //encrypts fields tagged with audit:"encrypt" of superseded versions
+	HashKey           []byte           //key of the HMAC-SHA256 of fields tagged with audit:"hash", unkeyed SHA-256 when empty
+	MetadataKeys      []string         //metadata keys stored from the context, all of them when empty
+	Outbox            bool             //records an AuditEvent of each change in OutboxEvent, in the transaction of the change
+	Events            *EventStream     //delivers an AuditEvent of each change to in-process subscribers after it commits
+	Logger            *slog.Logger     //logs audited creates, updates and deletes, and their failures
+	LogLevel          slog.Leveler     //level of the logged operations, Info when nil
+	FailureLogLevel   slog.Leveler     //level of the logged failures, Error when nil
+	Tracer            Tracer           //traces the transaction of updates and its supersede and insert steps
+}
 
 func (a MegaGormAuditPlugin) Name() string {
 	return "MegaGormAuditPlugin"
 }
 
 func (a MegaGormAuditPlugin) Initialize(db *gorm.DB) error {
-	err := db.Callback().Update().Replace("gorm:update", deleteAndCreate)
-	return err
+	create, update, remove := db.Callback().Create(), db.Callback().Update(), db.Callback().Delete()
+	err := firstError(
+		update.Replace("gorm:update", a.deleteAndCreate),
+		create.Replace("gorm:create", versionUpserts(create.Get("gorm:create"))),
+		create.Before("gorm:create").Register("megagormaudit:stamp_actor", stampActor),
+		create.Before("gorm:create").Register("megagormaudit:reason", stampReason),
+		create.Before("gorm:create").Register("megagormaudit:metadata", a.stampMetadata),
+		create.Before("gorm:create").Register("megagormaudit:hash", hashVersion),
+		create.After("gorm:create").Register("megagormaudit:create_events", a.createEvents),
+		create.Before("gorm:before_create").Register("megagormaudit:log_start", a.startLog),
+		create.After("gorm:after_create").Register("megagormaudit:log", a.logCreate),
+		remove.Before("gorm:before_delete").Register("megagormaudit:log_start", a.startLog),
+		remove.After("gorm:after_delete").Register("megagormaudit:log", a.logDelete),
+		remove.After("gorm:delete").Register("megagormaudit:after_delete", a.afterDelete),
+		create.Before("gorm:save_before_associations").Register("megagormaudit:actor", contextActor),
+		update.Before("gorm:save_before_associations").Register("megagormaudit:actor", contextActor),
+		create.Before("gorm:create").Register("megagormaudit:before_join_insert", a.beforeJoinInsert),
+		create.After("gorm:create").Register("megagormaudit:after_join_insert", recordJoinRows(JoinTableInsert)),
+		remove.Before("gorm:delete").Register("megagormaudit:before_join_delete", a.beforeJoinDelete),
+		remove.After("gorm:delete").Register("megagormaudit:after_join_delete", recordJoinRows(JoinTableDelete)),
+		update.Before("gorm:begin_transaction").Register("megagormaudit:native_association", a.guardAssociation),
+		remove.Before("gorm:begin_transaction").Register("megagormaudit:native_association", a.guardAssociation),
+		db.Callback().Raw().Before("gorm:raw").Register("megagormaudit:guard_raw", a.guardRaw),
+		db.Callback().Raw().After("gorm:raw").Register("megagormaudit:savepoints", trackSavepoints),
+		db.Callback().Query().Before("gorm:query").Register("megagormaudit:guard_raw", a.guardRaw),
+		db.Callback().Row().Before("gorm:row").Register("megagormaudit:guard_raw", a.guardRaw),
+	)
+	if err != nil {
+		return err
+	}
+
+	if a.Events != nil {
+		pool := &streamPool{ConnPool: db.ConnPool, stream: a.Events}
+		db.ConnPool, db.Statement.ConnPool = pool, pool
+	}
+
+	for _, model := range a.Models {
+		auditSchema, err := auditableSchema(db, model)
+		if err != nil {
+			return err
+		}
+		registerAuditableTable(db, auditSchema.Table)
+	}
+	return nil
 }
 
-func deleteAndCreate(db *gorm.DB) {
-	auditableModelReflect := reflect.ValueOf(db.Statement.Model).Elem().FieldByName("AuditableModel")
+// firstError returns the first of errs that isn't nil.
+func firstError(errs ...error) error {
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (a MegaGormAuditPlugin) deleteAndCreate(db *gorm.DB) {
+	if db.Error != nil || a.guardUpdate(db) {
+		return
+	}
 
-	if auditableModelReflect.IsValid() {
+	modelReflect := reflect.Indirect(reflect.ValueOf(db.Statement.Model))
+	var auditableModelReflect reflect.Value
+	if modelReflect.Kind() == reflect.Struct {
+		auditableModelReflect = modelReflect.FieldByName("AuditableModel")
+	}
+
+	if auditableModelReflect.IsValid() && !associationsOnly(db.Statement) {
+		start := time.Now()
 		auditableModel := auditableModelReflect.Interface().(AuditableModel)
+		if err := applyDest(db.Statement, modelReflect); err != nil {
+			db.AddError(err)
+			a.logOperation(db.Statement.Context, EventUpdate, db.Statement.Schema.Table, start, err,
+				slog.Uint64("old_id", uint64(auditableModel.ID)), slog.String("actor", auditableModel.LastChangedUser))
+			return
+		}
 
-		err := db.Transaction(func(tx *gorm.DB) error {
+		auditableModel = auditableModelReflect.Interface().(AuditableModel)
+		children := auditableChildren(db.Statement.Schema)
+		for _, rel := range children {
+			db.Statement.Omits = append(db.Statement.Omits, rel.Name)
+		}
+
+		entityID := auditableModel.ID
+		if auditableModel.AuditParentID != nil {
+			entityID = *auditableModel.AuditParentID
+		}
+		table := db.Statement.Schema.Table
+		ctx, span := a.startSpan(db.Statement.Context, SpanUpdate, table, entityID, auditableModel.ID)
+		transaction := db
+		if a.Tracer != nil {
+			transaction = db.WithContext(ctx)
+		}
+
+		err := transaction.Transaction(func(tx *gorm.DB) error {
+
+			previous, err := loadVersion(tx, db.Statement.Model, auditableModel.ID)
+			if err == nil && auditableModelOf(previous).DeletedAt != 0 {
+				err = ErrImmutableVersion
+			}
+			if err != nil {
+				db.AddError(err)
+				return err
+			}
+			current := auditableModelReflect.Addr().Interface().(*AuditableModel)
+			clearInheritedReason(db.Statement.Context, current, previous)
+			a.clearInheritedMetadata(db.Statement.Context, current, previous)
 
-			if err := tx.Delete(db.Statement.Model).Error; err != nil {
+			if fields := changedImmutableFields(db.Statement.Context, db.Statement.Schema, previous, db.Statement.Model); len(fields) > 0 {
+				err := &ImmutableFieldsError{Fields: fields}
 				db.AddError(err)
 				return err
 			}
 
-			db.Statement.SetColumn("id", 0)
-			if auditableModel.AuditParentID != nil {
-				db.Statement.SetColumn("audit_parent_id", auditableModel.AuditParentID)
-			} else {
-				db.Statement.SetColumn("audit_parent_id", auditableModel.ID)
+			if columns := inPlaceColumns(db.Statement.Context, db.Statement.Schema, previous, db.Statement.Model); len(columns) > 0 {
+				result := AllowBypass(tx.Session(&gorm.Session{NewDB: true, SkipHooks: true})).Model(db.Statement.Model).Select(columns).Updates(db.Statement.Model)
+				if result.Error != nil {
+					db.AddError(result.Error)
+					return result.Error
+				}
+				db.RowsAffected = result.RowsAffected
+				if a.emitsEvents() {
+					if err := a.emit(tx, auditEvent(tx, db.Statement.Schema, EventUpdate, previous, db.Statement.Model)); err != nil {
+						db.AddError(err)
+						return err
+					}
+				}
+				return nil
 			}
-			db.Statement.SetColumn("deleted_at", nil)
 
-			if err := tx.Create(db.Statement.Model).Error; err != nil {
+			hookTx := tx.Session(&gorm.Session{NewDB: true})
+			if err := a.beforeVersion(hookTx, db.Statement.Model, previous, db.Statement.Model); err != nil {
 				db.AddError(err)
 				return err
 			}
 
+			if err := a.checkReferences(tx, db.Statement.Schema, auditableModel.ID); err != nil {
+				db.AddError(err)
+				return err
+			}
+
+			err = a.traced(tx, SpanSupersede, table, entityID, auditableModel.ID, func(tx *gorm.DB, span Span) error {
+				superseding := tx.Set(supersedingKey, previous)
+				superseding.Statement.SkipHooks = false
+				if err := superseding.Delete(db.Statement.Model).Error; err != nil {
+					return err
+				}
+				return a.redactVersion(tx, db.Statement.Schema, previous, auditableModel.ID)
+			})
+			if err != nil {
+				db.AddError(err)
+				return err
+			}
+
+			err = a.traced(tx, SpanInsert, table, entityID, 0, func(tx *gorm.DB, span Span) error {
+				parentID := auditableModel.AuditParentID
+				if parentID == nil {
+					parentID = &auditableModel.ID
+				}
+				version := auditableModelReflect.Addr().Interface().(*AuditableModel)
+				version.ID, version.AuditParentID = 0, parentID
+				version.AuditPrevHash = auditableModelOf(previous).AuditHash
+				//models can redeclare DeletedAt for their unique indexes
+				db.Statement.Schema.LookUpField("deleted_at").ReflectValueOf(db.Statement.Context, modelReflect).SetZero()
+
+				if err := tx.Set(versionInsertKey, true).Select("*").Omit(clause.Associations).Create(db.Statement.Model).Error; err != nil {
+					return err
+				}
+				span.SetAttribute(AttributeVersion, auditableModelOf(db.Statement.Model).ID)
+				return nil
+			})
+			if err != nil {
+				db.AddError(err)
+				return err
+			}
+
+			newID := auditableModelOf(db.Statement.Model).ID
+			if err := a.carryJoinRows(tx, db.Statement.Schema, auditableModel.ID, newID, actorOf(db.Statement.Model)); err != nil {
+				db.AddError(err)
+				return err
+			}
+
+			if err := createChildren(tx, children, db.Statement.Model, newID); err != nil {
+				db.AddError(err)
+				return err
+			}
+
+			if err := a.remapReferences(tx, db.Statement.Schema, auditableModel.ID, newID); err != nil {
+				db.AddError(err)
+				return err
+			}
+
+			if a.emitsEvents() {
+				if err := a.emit(tx, auditEvent(tx, db.Statement.Schema, EventUpdate, previous, db.Statement.Model)); err != nil {
+					db.AddError(err)
+					return err
+				}
+			}
+
+			a.afterVersion(hookTx, db.Statement.Model, previous, db.Statement.Model)
 			return nil
 		})
 
+		endSpan(span, err)
+		a.logOperation(db.Statement.Context, EventUpdate, db.Statement.Schema.Table, start, err,
+			slog.Uint64("old_id", uint64(auditableModel.ID)), slog.Uint64("new_id", uint64(auditableModelOf(db.Statement.Model).ID)),
+			slog.String("actor", actorOf(db.Statement.Model)))
 		if err != nil {
 			db.AddError(err)
 			return
@@ -58,19 +252,74 @@ func deleteAndCreate(db *gorm.DB) {
 	}
 }
 
+// loadVersion loads the stored version of model identified by id.
+func loadVersion(tx *gorm.DB, model interface{}, id uint) (interface{}, error) {
+	version := reflect.New(reflect.Indirect(reflect.ValueOf(model)).Type()).Interface()
+	err := tx.Session(&gorm.Session{NewDB: true, SkipHooks: true}).Unscoped().Where("id = ?", id).Take(version).Error
+	return version, err
+}
+
 func (u *AuditableModel) BeforeDelete(tx *gorm.DB) (err error) {
 
 	curTime := tx.Statement.DB.NowFunc()
 	nano := curTime.UnixMilli()
+	if actor := actorFromContext(tx.Statement.Context); actor != "" {
+		u.LastChangedUser = actor
+	}
+	if reason := reasonFromContext(tx.Statement.Context); reason != "" {
+		u.ChangeReason = reason
+	}
+	if metadata := pluginOf(tx).contextMetadata(tx.Statement.Context); metadata != nil {
+		u.AuditMetadata = metadata
+	}
+	stamp := versionStamp{DeletedAt: nano, LastChangedUser: u.LastChangedUser, ChangeReason: u.ChangeReason, AuditMetadata: u.AuditMetadata}
 
-	tx.Statement.AddClause(clause.Update{})
-	tx.Statement.AddClause(clause.Set{
-		{Column: clause.Column{Name: "deleted_at"}, Value: nano},
-		{Column: clause.Column{Name: "last_changed_user"}, Value: u.LastChangedUser},
-	})
+	auditHash := u.AuditHash
+	if previous, superseding := tx.Statement.Settings.Load(supersedingKey); superseding {
+		auditHash = auditableModelOf(previous).AuditHash
+	} else {
+		old, err := loadVersion(tx, tx.Statement.Model, u.ID)
+		if err == nil {
+			auditHash = auditableModelOf(old).AuditHash
+			clearInheritedReason(tx.Statement.Context, u, old)
+			pluginOf(tx).clearInheritedMetadata(tx.Statement.Context, u, old)
+			stamp.ChangeReason, stamp.AuditMetadata = u.ChangeReason, u.AuditMetadata
+		}
+		if err == nil && auditableModelOf(old).DeletedAt != 0 && !bypassAllowed(tx) {
+			return ErrImmutableVersion
+		}
+
+		plugin := pluginOf(tx)
+		//deleting a missing row stays a no-op, without versions for the hooks
+		if plugin.hasVersionHooks(tx.Statement.Model) && !errors.Is(err, gorm.ErrRecordNotFound) {
+			if err != nil {
+				return err
+			}
+
+			if err := plugin.beforeVersion(tx, tx.Statement.Model, old, nil); err != nil {
+				return err
+			}
+		}
+		if err == nil {
+			tx.Statement.Settings.Store(deletedVersionKey, old)
+			if auditableModelOf(old).DeletedAt == 0 {
+				if err := plugin.redactVersion(tx, tx.Statement.Schema, old, u.ID); err != nil {
+					return err
+				}
+			}
+		}
 
-	tx.Statement.SetColumn("deleted_at", nano)
-	tx.Statement.SetColumn("last_changed_user", u.LastChangedUser)
+		if err := cascadeDelete(tx, tx.Statement.Schema, []uint{u.ID}, stamp); err != nil {
+			return err
+		}
+	}
+
+	set := stamp.set(auditHash)
+	tx.Statement.AddClause(clause.Update{})
+	tx.Statement.AddClause(set)
+	for _, assignment := range set {
+		tx.Statement.SetColumn(assignment.Column.Name, assignment.Value)
+	}
 
 	tx.Statement.AddClause(clause.Where{Exprs: []clause.Expression{
 		clause.Eq{Column: clause.PrimaryColumn, Value: u.ID},
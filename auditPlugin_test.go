@@ -1,12 +1,16 @@
 package MegaGormAudit
 
 import (
+	"context"
+	"database/sql"
 	"errors"
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
 	"gorm.io/gorm/clause"
+	"gorm.io/gorm/logger"
 	"gorm.io/plugin/soft_delete"
 	"reflect"
+	"regexp"
 	"strings"
 	"testing"
 	"time"
@@ -771,18 +775,194 @@ func TestAuditPlugin_AuditableModel(t *testing.T) {
 	}
 }
 
+func TestAuditPlugin_ImmutableVersions(t *testing.T) {
+
+	tests := []struct {
+		name    string
+		change  func(db *gorm.DB, superseded *Account) error
+		wantErr error
+	}{
+		{
+			name: "Reject update of superseded version",
+			change: func(db *gorm.DB, superseded *Account) error {
+				superseded.Balance = 30
+				return db.Updates(superseded).Error
+			},
+			wantErr: ErrImmutableVersion,
+		},
+		{
+			name: "Reject update column of superseded version",
+			change: func(db *gorm.DB, superseded *Account) error {
+				return db.Model(superseded).UpdateColumn("balance", 30).Error
+			},
+			wantErr: ErrImmutableVersion,
+		},
+		{
+			name: "Reject delete of superseded version",
+			change: func(db *gorm.DB, superseded *Account) error {
+				return db.Delete(superseded).Error
+			},
+			wantErr: ErrImmutableVersion,
+		},
+		{
+			name: "Reject skipped delete of superseded version",
+			change: func(db *gorm.DB, superseded *Account) error {
+				return db.Scopes(Skip).Delete(superseded).Error
+			},
+			wantErr: ErrImmutableVersion,
+		},
+		{
+			name: "Success, allowed bypass of superseded version delete",
+			change: func(db *gorm.DB, superseded *Account) error {
+				return db.Scopes(AllowBypass).Delete(superseded).Error
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, err := createDatabase()
+			if err != nil {
+				t.Errorf("createDatabase() error = %v", err)
+				return
+			}
+
+			err = db.AutoMigrate(Account{})
+			if err != nil {
+				t.Errorf("AutoMigrate() error = %v", err)
+				return
+			}
+
+			account := &Account{Owner: "alice", Balance: 10}
+			db.Create(account)
+			superseded := *account
+			account.Balance = 20
+			err = db.Updates(account).Error
+			if err != nil {
+				t.Errorf("Updates() error = %v", err)
+				return
+			}
+
+			err = tt.change(db, &superseded)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("change() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+
+			var accounts []Account
+			db.Unscoped().Order("id").Find(&accounts)
+			if len(accounts) != 2 || accounts[0].Balance != 10 || accounts[1].Balance != 20 || accounts[1].DeletedAt != 0 {
+				t.Errorf("versions = %v, want the history unchanged", accounts)
+			}
+		})
+	}
+}
+
+func TestAuditPlugin_Initialize(t *testing.T) {
+
+	tests := []struct {
+		name     string
+		register func(db *gorm.DB)
+		wantErr  bool
+	}{
+		{
+			name: "Success",
+		},
+		{
+			name: "Fail on conflicting callbacks",
+			register: func(db *gorm.DB) {
+				_ = db.Callback().Create().Before("gorm:begin_transaction").After("gorm:commit_or_rollback_transaction").Register("test:conflict", func(*gorm.DB) {})
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, err := gorm.Open(sqlite.Open(""), &gorm.Config{Logger: logger.Discard})
+			if err != nil {
+				t.Errorf("Open() error = %v", err)
+				return
+			}
+			if tt.register != nil {
+				tt.register(db)
+			}
+
+			err = db.Use(MegaGormAuditPlugin{})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Use() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
 func createDatabase() (*gorm.DB, error) {
+	return createDatabaseWithPlugin(MegaGormAuditPlugin{})
+}
+
+func createDatabaseWithPlugin(plugin MegaGormAuditPlugin) (*gorm.DB, error) {
 	db, err := gorm.Open(sqlite.Open(""), &gorm.Config{})
 	if err != nil {
 		return nil, err
 	}
-	err = db.Use(MegaGormAuditPlugin{})
+	err = db.Use(plugin)
 	if err != nil {
 		return nil, err
 	}
 	return db, nil
 }
 
+var errFailingStatement = errors.New("failing statement")
+
+// failingConn fails the statements matching pattern.
+type failingConn struct {
+	gorm.ConnPool
+	pattern *regexp.Regexp
+}
+
+// failingPool is a failingConn whose transactions fail the same statements.
+type failingPool struct {
+	failingConn
+}
+
+type failingTx struct {
+	failingConn
+}
+
+// failOn makes the statements of db matching pattern fail with errFailingStatement.
+func failOn(db *gorm.DB, pattern string) {
+	pool := &failingPool{failingConn{ConnPool: db.ConnPool, pattern: regexp.MustCompile(pattern)}}
+	db.ConnPool, db.Statement.ConnPool = pool, pool
+}
+
+func (c failingConn) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	if c.pattern.MatchString(query) {
+		return nil, errFailingStatement
+	}
+	return c.ConnPool.ExecContext(ctx, query, args...)
+}
+
+func (c failingConn) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	if c.pattern.MatchString(query) {
+		return nil, errFailingStatement
+	}
+	return c.ConnPool.QueryContext(ctx, query, args...)
+}
+
+func (p *failingPool) BeginTx(ctx context.Context, opts *sql.TxOptions) (gorm.ConnPool, error) {
+	tx, err := p.ConnPool.(gorm.TxBeginner).BeginTx(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &failingTx{failingConn{ConnPool: tx, pattern: p.pattern}}, nil
+}
+
+func (t *failingTx) Commit() error {
+	return t.ConnPool.(gorm.TxCommitter).Commit()
+}
+
+func (t *failingTx) Rollback() error {
+	return t.ConnPool.(gorm.TxCommitter).Rollback()
+}
+
 func deletedAtNull() gorm.DeletedAt {
 	return gorm.DeletedAt{
 		Time:  time.Time{},
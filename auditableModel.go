@@ -1,10 +1,19 @@
 package MegaGormAudit
 
 import (
+	"errors"
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
 	"gorm.io/plugin/soft_delete"
+	"reflect"
+	"strings"
 	"time"
 )
 
+var ErrNotAuditable = errors.New("megagormaudit: model does not embed AuditableModel")
+
+var ErrImmutableVersion = errors.New("megagormaudit: superseded versions can't be changed")
+
 type AuditableModel struct {
 	ID              uint            `gorm:"primarykey" auditable:"true"`
 	AuditParentID   *uint           `gorm:"default:null"`
@@ -13,4 +22,43 @@ type AuditableModel struct {
 	UpdatedAt       time.Time
 	DeletedAt       soft_delete.DeletedAt
 	LastChangedUser string
+	ChangeReason    string
+	AuditMetadata   Metadata
+	AuditHash       string
+	AuditPrevHash   string
+	AuditStampHash  string
+	AuditSealHash   string
+}
+
+// auditableSchema parses model and returns its schema when it embeds AuditableModel.
+func auditableSchema(db *gorm.DB, model interface{}) (*schema.Schema, error) {
+	stmt := &gorm.Statement{DB: db}
+	if err := stmt.Parse(model); err != nil {
+		return nil, err
+	}
+
+	if !isAuditableSchema(stmt.Schema) {
+		return nil, ErrNotAuditable
+	}
+	return stmt.Schema, nil
+}
+
+func isAuditableSchema(s *schema.Schema) bool {
+	_, ok := s.ModelType.FieldByName("AuditableModel")
+	return ok
+}
+
+// auditableModelOf returns the AuditableModel embedded in value.
+func auditableModelOf(value interface{}) AuditableModel {
+	return reflect.Indirect(reflect.ValueOf(value)).FieldByName("AuditableModel").Interface().(AuditableModel)
+}
+
+// hasAuditTag reports whether the audit struct tag of field, with values separated by ";", contains value.
+func hasAuditTag(field *schema.Field, value string) bool {
+	for _, tag := range strings.Split(field.Tag.Get("audit"), ";") {
+		if strings.TrimSpace(tag) == value {
+			return true
+		}
+	}
+	return false
 }
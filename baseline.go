@@ -0,0 +1,90 @@
+package MegaGormAudit
+
+import (
+	"context"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"reflect"
+)
+
+// Baseline hashes the versions of model, including archived versions, stored without a hash,
+// such as the versions written before the plugin hashed them, so Verify accepts them from then on.
+// Hashed versions that follow a baselined one are linked to it through a chain anchor.
+// It returns the number of baselined versions.
+func Baseline(ctx context.Context, db *gorm.DB, model interface{}) (int64, error) {
+	db = db.WithContext(ctx)
+	auditSchema, err := auditableSchema(db, model)
+	if err != nil {
+		return 0, err
+	}
+
+	versions := reflect.New(reflect.SliceOf(auditSchema.ModelType))
+	if err := findVersions(db, auditSchema.Table, versions.Interface()); err != nil {
+		return 0, err
+	}
+
+	var baselined []*AuditableModel
+	var anchors []ChainAnchor
+	lastHashes := map[uint]string{}
+	lastBaselined := map[uint]bool{}
+	for i := 0; i < versions.Elem().Len(); i++ {
+		rv := versions.Elem().Index(i)
+		version := rv.FieldByName("AuditableModel").Addr().Interface().(*AuditableModel)
+
+		rootID := version.ID
+		if version.AuditParentID != nil {
+			rootID = *version.AuditParentID
+		}
+
+		if version.AuditHash != "" {
+			if lastBaselined[rootID] && version.AuditPrevHash != lastHashes[rootID] {
+				anchors = append(anchors, ChainAnchor{AuditTable: auditSchema.Table, VersionID: version.ID, FromHash: lastHashes[rootID], PrevHash: version.AuditPrevHash})
+			}
+			lastBaselined[rootID] = false
+			lastHashes[rootID] = version.AuditHash
+			continue
+		}
+
+		version.AuditPrevHash = lastHashes[rootID]
+		version.AuditStampHash = stampHash(version.LastChangedUser, version.ChangeReason, version.AuditMetadata)
+		version.AuditHash = contentHash(ctx, auditSchema, rv)
+		version.AuditSealHash = ""
+		if version.DeletedAt != 0 {
+			version.AuditSealHash = sealHash(version.AuditHash, versionStamp{DeletedAt: int64(version.DeletedAt), LastChangedUser: version.LastChangedUser, ChangeReason: version.ChangeReason, AuditMetadata: version.AuditMetadata})
+		}
+		baselined = append(baselined, version)
+		lastBaselined[rootID] = true
+		lastHashes[rootID] = version.AuditHash
+	}
+	if len(baselined) == 0 {
+		return 0, nil
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		for _, version := range baselined {
+			//archived versions aren't in the main table
+			for _, table := range []string{auditSchema.Table, archiveTable(auditSchema.Table)} {
+				result := AllowBypass(tx).Exec("UPDATE ? SET audit_prev_hash = ?, audit_stamp_hash = ?, audit_hash = ?, audit_seal_hash = ? WHERE id = ?",
+					clause.Table{Name: table}, version.AuditPrevHash, version.AuditStampHash, version.AuditHash, version.AuditSealHash, version.ID)
+				if result.Error != nil {
+					return result.Error
+				}
+				if result.RowsAffected > 0 {
+					break
+				}
+			}
+		}
+
+		if len(anchors) == 0 {
+			return nil
+		}
+		if err := tx.AutoMigrate(&ChainAnchor{}); err != nil {
+			return err
+		}
+		return tx.Create(&anchors).Error
+	})
+	if err != nil {
+		return 0, err
+	}
+	return int64(len(baselined)), nil
+}
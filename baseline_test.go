@@ -0,0 +1,176 @@
+package MegaGormAudit
+
+import (
+	"context"
+	"errors"
+	"gorm.io/gorm"
+	"testing"
+)
+
+// forgetHashes clears the hashes of the versions of table, as they were stored before the plugin hashed them.
+func forgetHashes(db *gorm.DB, table string) error {
+	return db.Exec("UPDATE " + table + " SET audit_hash = '', audit_prev_hash = '', audit_stamp_hash = '', audit_seal_hash = ''").Error
+}
+
+func TestBaseline(t *testing.T) {
+
+	tests := []struct {
+		name      string
+		prepare   func(db *gorm.DB, player *archivedPlayer) error
+		wantCount int64
+	}{
+		{
+			name: "Success, legacy versions",
+			prepare: func(db *gorm.DB, player *archivedPlayer) error {
+				return forgetHashes(db, "archived_players")
+			},
+			wantCount: 3,
+		},
+		{
+			name: "Success, version created after legacy versions",
+			prepare: func(db *gorm.DB, player *archivedPlayer) error {
+				if err := forgetHashes(db, "archived_players"); err != nil {
+					return err
+				}
+				player.Name = "teste depois"
+				return db.Updates(player).Error
+			},
+			wantCount: 3,
+		},
+		{
+			name: "Success, legacy archived versions",
+			prepare: func(db *gorm.DB, player *archivedPlayer) error {
+				if _, err := Archive(context.Background(), db, player, 0); err != nil {
+					return err
+				}
+				if err := forgetHashes(db, "archived_players"); err != nil {
+					return err
+				}
+				return forgetHashes(db, "archived_players_archive")
+			},
+			wantCount: 3,
+		},
+		{
+			name: "Success, nothing to baseline",
+			prepare: func(db *gorm.DB, player *archivedPlayer) error {
+				return nil
+			},
+			wantCount: 0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, err := createDatabase()
+			if err != nil {
+				t.Errorf("createDatabase() error = %v", err)
+				return
+			}
+
+			err = db.AutoMigrate(archivedPlayer{})
+			if err != nil {
+				t.Errorf("AutoMigrate() error = %v", err)
+				return
+			}
+
+			player := &archivedPlayer{Name: "teste"}
+			db.Create(player)
+			for i := 0; i < 2; i++ {
+				player.Name = "teste atualizado"
+				db.Updates(player)
+			}
+
+			err = tt.prepare(db, player)
+			if err != nil {
+				t.Errorf("prepare() error = %v", err)
+				return
+			}
+
+			tampered, err := Verify(context.Background(), db, &archivedPlayer{})
+			if err != nil || (tampered != nil) != (tt.wantCount > 0) {
+				t.Errorf("Verify() before Baseline() = %v, %v", tampered, err)
+				return
+			}
+
+			count, err := Baseline(context.Background(), db, &archivedPlayer{})
+			if err != nil || count != tt.wantCount {
+				t.Errorf("Baseline() = %v, %v, want %v", count, err, tt.wantCount)
+				return
+			}
+
+			tampered, err = Verify(context.Background(), db, &archivedPlayer{})
+			if err != nil || tampered != nil {
+				t.Errorf("Verify() = %v, %v, want no tampered versions", tampered, err)
+			}
+		})
+	}
+}
+
+func TestBaseline_NotAuditable(t *testing.T) {
+	db, err := createDatabase()
+	if err != nil {
+		t.Errorf("createDatabase() error = %v", err)
+		return
+	}
+
+	_, err = Baseline(context.Background(), db, &ChainAnchor{})
+	if !errors.Is(err, ErrNotAuditable) {
+		t.Errorf("Baseline() error = %v, want %v", err, ErrNotAuditable)
+	}
+}
+
+func TestBaseline_FailOnDatabase(t *testing.T) {
+
+	tests := []struct {
+		name    string
+		created bool
+		pattern string
+	}{
+		{
+			name:    "Fail loading versions",
+			pattern: "^SELECT \\* FROM `archived_players`",
+		},
+		{
+			name:    "Fail storing hashes",
+			pattern: "^UPDATE `archived_players`",
+		},
+		{
+			name:    "Fail creating the anchors table",
+			created: true,
+			pattern: "^CREATE TABLE `audit_chain_anchors`",
+		},
+		{
+			name:    "Fail recording anchors",
+			created: true,
+			pattern: "^INSERT INTO `audit_chain_anchors`",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, err := createDatabase()
+			if err != nil {
+				t.Errorf("createDatabase() error = %v", err)
+				return
+			}
+
+			err = db.AutoMigrate(archivedPlayer{})
+			if err != nil {
+				t.Errorf("AutoMigrate() error = %v", err)
+				return
+			}
+
+			player := &archivedPlayer{Name: "teste"}
+			db.Create(player)
+			forgetHashes(db, "archived_players")
+			if tt.created {
+				player.Name = "teste atualizado"
+				db.Updates(player)
+			}
+
+			failOn(db, tt.pattern)
+			_, err = Baseline(context.Background(), db, &archivedPlayer{})
+			if !errors.Is(err, errFailingStatement) {
+				t.Errorf("Baseline() error = %v, want %v", err, errFailingStatement)
+			}
+		})
+	}
+}
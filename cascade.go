@@ -0,0 +1,72 @@
+package MegaGormAudit
+
+import (
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+	"reflect"
+	"strings"
+)
+
+// cascades returns the has one and has many auditable relationships of the schema that are soft deleted
+// with it, tagged with audit:"cascade" or constraint:OnDelete:CASCADE.
+func cascades(auditSchema *schema.Schema) []*schema.Relationship {
+	var rels []*schema.Relationship
+	for _, rel := range auditableChildren(auditSchema) {
+		constraint := rel.ParseConstraint()
+		if hasAuditTag(rel.Field, "cascade") || (constraint != nil && strings.EqualFold(constraint.OnDelete, "CASCADE")) {
+			rels = append(rels, rel)
+		}
+	}
+	return rels
+}
+
+// cascadeDelete soft deletes the live children of the versions ids of auditSchema, and their own children,
+// with the same deletion stamp of the parent, each sealed with its own hash and with its sensitive fields redacted.
+// The deletion of each child calls the version hooks and emits its own event.
+func cascadeDelete(tx *gorm.DB, auditSchema *schema.Schema, ids []uint, stamp versionStamp) error {
+	plugin := pluginOf(tx)
+	for _, rel := range cascades(auditSchema) {
+		ref := ownReference(rel)
+
+		children := reflect.New(reflect.SliceOf(reflect.PointerTo(rel.FieldSchema.ModelType)))
+		err := tx.Session(&gorm.Session{NewDB: true, SkipHooks: true}).Unscoped().Table(rel.FieldSchema.Table).
+			Where("? IN ? AND deleted_at = 0", clause.Column{Name: ref.ForeignKey.DBName}, ids).
+			Order("id").Find(children.Interface()).Error
+		if err != nil {
+			return err
+		}
+
+		var childIDs []uint
+		hookTx := tx.Session(&gorm.Session{NewDB: true})
+		for _, child := range reflectModels(children.Elem()) {
+			auditableModel := auditableModelOf(child)
+			if err := plugin.beforeVersion(hookTx, child, child, nil); err != nil {
+				return err
+			}
+			if err := sealVersion(tx, rel.FieldSchema.Table, auditableModel.ID, auditableModel.AuditHash, stamp); err != nil {
+				return err
+			}
+			if err := plugin.redactVersion(tx, rel.FieldSchema, child, auditableModel.ID); err != nil {
+				return err
+			}
+			if plugin.emitsEvents() {
+				event := auditEvent(tx, rel.FieldSchema, EventDelete, child, nil)
+				event.Actor, event.Reason, event.Metadata = stamp.LastChangedUser, stamp.ChangeReason, stamp.AuditMetadata
+				if err := plugin.emit(tx, event); err != nil {
+					return err
+				}
+			}
+			plugin.afterVersion(hookTx, child, child, nil)
+			childIDs = append(childIDs, auditableModel.ID)
+		}
+		if len(childIDs) == 0 {
+			continue
+		}
+
+		if err := cascadeDelete(tx, rel.FieldSchema, childIDs, stamp); err != nil {
+			return err
+		}
+	}
+	return nil
+}
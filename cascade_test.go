@@ -0,0 +1,289 @@
+package MegaGormAudit
+
+import (
+	"context"
+	"errors"
+	"gorm.io/gorm"
+	"testing"
+)
+
+type Shelf struct {
+	AuditableModel
+	Name  string
+	Books []ShelfBook `audit:"cascade"`
+}
+
+type ShelfBook struct {
+	AuditableModel
+	ShelfID uint
+	Title   string
+}
+
+type vetoingBookHook struct{}
+
+func (vetoingBookHook) BeforeAuditVersion(tx *gorm.DB, old, new interface{}) error {
+	if book, ok := old.(*ShelfBook); ok && book.Title == "veto" {
+		return errVetoedVersion
+	}
+	return nil
+}
+
+func TestAuditPlugin_CascadeDelete(t *testing.T) {
+
+	type ItemNote struct {
+		AuditableModel
+		OrderItemID uint
+		Text        string
+	}
+	type OrderItem struct {
+		AuditableModel
+		OrderID uint
+		Product string
+		Notes   []ItemNote `gorm:"constraint:OnDelete:CASCADE"`
+	}
+	type OrderComment struct {
+		AuditableModel
+		OrderID uint
+		Text    string
+	}
+	type Order struct {
+		AuditableModel
+		Code     string
+		Items    []OrderItem `audit:"cascade"`
+		Comments []OrderComment
+	}
+
+	liveOrder := func(db *gorm.DB) bool {
+		var orders []Order
+		var items []OrderItem
+		db.Find(&orders)
+		db.Find(&items)
+		return len(orders) == 1 && len(items) == 2
+	}
+
+	tests := []struct {
+		name        string
+		pattern     string
+		change      func(db *gorm.DB, order *Order) error
+		wantErr     error
+		successTest func(db *gorm.DB) bool
+	}{
+		{
+			name: "Success, delete cascades to tagged children and grandchildren",
+			change: func(db *gorm.DB, order *Order) error {
+				order.LastChangedUser = "deleter"
+				return db.Delete(order).Error
+			},
+			successTest: func(db *gorm.DB) bool {
+				var order Order
+				var items []OrderItem
+				var notes []ItemNote
+				db.Unscoped().First(&order)
+				db.Unscoped().Find(&items)
+				db.Unscoped().Find(&notes)
+				if order.DeletedAt == 0 || len(items) != 2 || len(notes) != 1 {
+					return false
+				}
+				for _, item := range items {
+					if item.DeletedAt != order.DeletedAt || item.LastChangedUser != "deleter" {
+						return false
+					}
+				}
+				if notes[0].DeletedAt != order.DeletedAt || notes[0].LastChangedUser != "deleter" {
+					return false
+				}
+				for _, model := range []interface{}{Order{}, OrderItem{}, ItemNote{}} {
+					if tampered, err := Verify(context.Background(), db, model); err != nil || len(tampered) > 0 {
+						return false
+					}
+				}
+				return true
+			},
+		},
+		{
+			name: "Success, delete keeps children without cascade",
+			change: func(db *gorm.DB, order *Order) error {
+				return db.Delete(order).Error
+			},
+			successTest: func(db *gorm.DB) bool {
+				var comments []OrderComment
+				db.Find(&comments)
+				return len(comments) == 1
+			},
+		},
+		{
+			name: "Success, delete keeps deletion of already deleted children",
+			change: func(db *gorm.DB, order *Order) error {
+				if err := db.Delete(&order.Items[1]).Error; err != nil {
+					return err
+				}
+				return db.Delete(order).Error
+			},
+			successTest: func(db *gorm.DB) bool {
+				var order Order
+				var items []OrderItem
+				db.Unscoped().First(&order)
+				db.Unscoped().Order("id").Find(&items)
+				return len(items) == 2 && items[0].DeletedAt == order.DeletedAt && items[1].DeletedAt > 0 &&
+					items[1].LastChangedUser == "creator"
+			},
+		},
+		{
+			name: "Success, update doesn't cascade",
+			change: func(db *gorm.DB, order *Order) error {
+				order.Code = "updated"
+				return db.Updates(order).Error
+			},
+			successTest: func(db *gorm.DB) bool {
+				var items []OrderItem
+				var notes []ItemNote
+				db.Find(&items)
+				db.Find(&notes)
+				return len(items) == 2 && len(notes) == 1
+			},
+		},
+		{
+			name:    "Fail loading children",
+			pattern: "^SELECT \\* FROM `order_items` WHERE `order_id` IN",
+			change: func(db *gorm.DB, order *Order) error {
+				return db.Delete(order).Error
+			},
+			wantErr:     errFailingStatement,
+			successTest: liveOrder,
+		},
+		{
+			name:    "Fail cascading to grandchildren",
+			pattern: "^UPDATE `item_notes` SET",
+			change: func(db *gorm.DB, order *Order) error {
+				return db.Delete(order).Error
+			},
+			wantErr: errFailingStatement,
+			successTest: func(db *gorm.DB) bool {
+				var notes []ItemNote
+				db.Find(&notes)
+				return liveOrder(db) && len(notes) == 1
+			},
+		},
+		{
+			name:    "Fail sealing children",
+			pattern: "^UPDATE `order_items` SET",
+			change: func(db *gorm.DB, order *Order) error {
+				return db.Delete(order).Error
+			},
+			wantErr:     errFailingStatement,
+			successTest: liveOrder,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, err := createDatabase()
+			if err != nil {
+				t.Errorf("createDatabase() error = %v", err)
+				return
+			}
+
+			err = db.AutoMigrate(Order{}, OrderItem{}, ItemNote{}, OrderComment{})
+			if err != nil {
+				t.Errorf("AutoMigrate() error = %v", err)
+				return
+			}
+
+			creator := AuditableModel{LastChangedUser: "creator"}
+			order := &Order{
+				AuditableModel: creator,
+				Code:           "order",
+				Items: []OrderItem{
+					{AuditableModel: creator, Product: "product", Notes: []ItemNote{{AuditableModel: creator, Text: "note"}}},
+					{AuditableModel: creator, Product: "other product"},
+				},
+				Comments: []OrderComment{{AuditableModel: creator, Text: "comment"}},
+			}
+			err = db.Create(order).Error
+			if err != nil {
+				t.Errorf("Create() error = %v", err)
+				return
+			}
+
+			if tt.pattern != "" {
+				failOn(db, tt.pattern)
+			}
+			err = tt.change(db, order)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("change() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+
+			if !tt.successTest(db) {
+				t.Errorf("successTest() = false, want true")
+			}
+		})
+	}
+}
+
+func TestAuditPlugin_CascadeVersionHooks(t *testing.T) {
+
+	tests := []struct {
+		name       string
+		title      string //of the second book
+		wantErr    error
+		wantBefore []versionHookCall
+		wantAfter  []versionHookCall
+		wantLive   int
+	}{
+		{
+			name:       "Success, hooks called for cascaded children",
+			title:      "other book",
+			wantBefore: []versionHookCall{{oldID: 1}, {oldID: 1}, {oldID: 2}},
+			wantAfter:  []versionHookCall{{oldID: 1}, {oldID: 2}, {oldID: 1}},
+		},
+		{
+			name:       "Vetoed cascade to a child",
+			title:      "veto",
+			wantErr:    errVetoedVersion,
+			wantBefore: []versionHookCall{{oldID: 1}, {oldID: 1}, {oldID: 2}},
+			wantAfter:  []versionHookCall{{oldID: 1}},
+			wantLive:   2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hook := &recordingVersionHook{}
+			db, err := createDatabaseWithPlugin(MegaGormAuditPlugin{Hooks: []interface{}{hook, vetoingBookHook{}}})
+			if err != nil {
+				t.Errorf("createDatabase() error = %v", err)
+				return
+			}
+
+			err = db.AutoMigrate(Shelf{}, ShelfBook{})
+			if err != nil {
+				t.Errorf("AutoMigrate() error = %v", err)
+				return
+			}
+
+			shelf := &Shelf{Name: "shelf", Books: []ShelfBook{{Title: "book"}, {Title: tt.title}}}
+			err = db.Create(shelf).Error
+			if err != nil {
+				t.Errorf("Create() error = %v", err)
+				return
+			}
+
+			err = db.Delete(shelf).Error
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("Delete() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+
+			if !equalVersionHookCalls(hook.before, tt.wantBefore) {
+				t.Errorf("BeforeAuditVersion() calls = %v, want %v", hook.before, tt.wantBefore)
+			}
+			if !equalVersionHookCalls(hook.after, tt.wantAfter) {
+				t.Errorf("AfterAuditVersion() calls = %v, want %v", hook.after, tt.wantAfter)
+			}
+			var live int64
+			db.Model(&ShelfBook{}).Count(&live)
+			if live != int64(tt.wantLive) {
+				t.Errorf("live books = %d, want %d", live, tt.wantLive)
+			}
+		})
+	}
+}
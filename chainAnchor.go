@@ -0,0 +1,105 @@
+package MegaGormAudit
+
+import (
+	"gorm.io/gorm"
+	"time"
+)
+
+// ChainAnchor links a version to the hash of its previous version when the versions between them
+// are removed by Purge or Archive, so Verify still accepts the chain.
+type ChainAnchor struct {
+	ID         uint `gorm:"primarykey"`
+	CreatedAt  time.Time
+	AuditTable string `gorm:"index:idx_audit_chain_anchors_version"`
+	VersionID  uint   `gorm:"index:idx_audit_chain_anchors_version"` //first version kept after the removed ones
+	FromHash   string //hash of the last version kept before the removed ones
+	PrevHash   string //hash of the last removed version
+}
+
+func (ChainAnchor) TableName() string {
+	return "audit_chain_anchors"
+}
+
+type chainVersion struct {
+	ID            uint
+	AuditParentID *uint
+	AuditHash     string
+	AuditPrevHash string
+}
+
+// recordAnchors records the anchors of the versions of table that follow the versions ids,
+// before they are removed. Anchors of the removed versions themselves are dropped.
+func recordAnchors(db *gorm.DB, table string, ids []uint) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	if err := db.AutoMigrate(&ChainAnchor{}); err != nil {
+		return err
+	}
+
+	var versions []chainVersion
+	if err := findVersions(db, table, &versions); err != nil {
+		return err
+	}
+
+	removed := make(map[uint]bool, len(ids))
+	for _, id := range ids {
+		removed[id] = true
+	}
+
+	var anchors []ChainAnchor
+	lastHashes := map[uint]string{}
+	gaps := map[uint]bool{}
+	for _, version := range versions {
+		rootID := version.ID
+		if version.AuditParentID != nil {
+			rootID = *version.AuditParentID
+		}
+
+		if removed[version.ID] {
+			gaps[rootID] = true
+			continue
+		}
+		if gaps[rootID] {
+			anchors = append(anchors, ChainAnchor{AuditTable: table, VersionID: version.ID, FromHash: lastHashes[rootID], PrevHash: version.AuditPrevHash})
+			gaps[rootID] = false
+		}
+		lastHashes[rootID] = version.AuditHash
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Where("audit_table = ? AND version_id IN ?", table, ids).Delete(&ChainAnchor{}).Error
+		if err != nil || len(anchors) == 0 {
+			return err
+		}
+		return tx.Create(&anchors).Error
+	})
+}
+
+// chainAnchors returns the anchors of table by version ID.
+func chainAnchors(db *gorm.DB, table string) (map[uint][]ChainAnchor, error) {
+	anchors := map[uint][]ChainAnchor{}
+	if !db.Migrator().HasTable(&ChainAnchor{}) {
+		return anchors, nil
+	}
+
+	var rows []ChainAnchor
+	if err := db.Where("audit_table = ?", table).Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	for _, anchor := range rows {
+		anchors[anchor.VersionID] = append(anchors[anchor.VersionID], anchor)
+	}
+	return anchors, nil
+}
+
+// anchored reports whether one of the anchors links the version with previous hash prevHash
+// to the version with hash fromHash.
+func anchored(anchors []ChainAnchor, fromHash, prevHash string) bool {
+	for _, anchor := range anchors {
+		if anchor.FromHash == fromHash && anchor.PrevHash == prevHash {
+			return true
+		}
+	}
+	return false
+}
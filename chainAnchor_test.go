@@ -0,0 +1,169 @@
+package MegaGormAudit
+
+import (
+	"context"
+	"errors"
+	"gorm.io/gorm"
+	"reflect"
+	"testing"
+)
+
+func TestChainAnchors(t *testing.T) {
+
+	tests := []struct {
+		name   string
+		change func(db *gorm.DB, player *PlayerWithRetention) error
+		want   []TamperedVersion
+	}{
+		{
+			name: "Success, purged again after new versions",
+			change: func(db *gorm.DB, player *PlayerWithRetention) error {
+				for i := 0; i < 2; i++ {
+					player.Name = "teste atualizado de novo"
+					if err := db.Updates(player).Error; err != nil {
+						return err
+					}
+				}
+				_, err := Purge(context.Background(), db, player)
+				return err
+			},
+			want: nil,
+		},
+		{
+			name: "Removed version outside Purge is reported",
+			change: func(db *gorm.DB, player *PlayerWithRetention) error {
+				return db.Exec("DELETE FROM player_with_retentions WHERE id = ?", 3).Error
+			},
+			want: []TamperedVersion{{ID: 4, Reason: ReasonPreviousHashMismatch}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, err := createDatabase()
+			if err != nil {
+				t.Errorf("createDatabase() error = %v", err)
+				return
+			}
+
+			err = db.AutoMigrate(PlayerWithRetention{})
+			if err != nil {
+				t.Errorf("AutoMigrate() error = %v", err)
+				return
+			}
+
+			player := &PlayerWithRetention{Name: "teste", policy: RetentionPolicy{KeepVersions: 1}}
+			err = db.Create(player).Error
+			if err != nil {
+				t.Errorf("Create() error = %v", err)
+				return
+			}
+			for i := 0; i < 3; i++ {
+				player.Name = "teste atualizado"
+				if err = db.Updates(player).Error; err != nil {
+					t.Errorf("Updates() error = %v", err)
+					return
+				}
+			}
+
+			if _, err = Purge(context.Background(), db, player); err != nil {
+				t.Errorf("Purge() error = %v", err)
+				return
+			}
+			if err = tt.change(db, player); err != nil {
+				t.Errorf("change() error = %v", err)
+				return
+			}
+
+			tampered, err := Verify(context.Background(), db, player)
+			if err != nil {
+				t.Errorf("Verify() error = %v", err)
+				return
+			}
+			if !reflect.DeepEqual(tampered, tt.want) {
+				t.Errorf("Verify() = %v, want %v", tampered, tt.want)
+			}
+		})
+	}
+}
+
+func TestChainAnchors_FailOnDatabase(t *testing.T) {
+
+	purge := func(db *gorm.DB, player *PlayerWithRetention) error {
+		_, err := Purge(context.Background(), db, player)
+		return err
+	}
+
+	tests := []struct {
+		name    string
+		purged  bool
+		pattern string
+		change  func(db *gorm.DB, player *PlayerWithRetention) error
+	}{
+		{
+			name:    "Fail creating the anchors table",
+			pattern: "^CREATE TABLE `audit_chain_anchors`",
+			change:  purge,
+		},
+		{
+			name:    "Fail creating the anchors table on archive",
+			pattern: "^CREATE TABLE `audit_chain_anchors`",
+			change: func(db *gorm.DB, player *PlayerWithRetention) error {
+				_, err := Archive(context.Background(), db, player, 0)
+				return err
+			},
+		},
+		{
+			name:    "Fail loading versions",
+			pattern: "^SELECT \\* FROM `player_with_retentions`",
+			change:  purge,
+		},
+		{
+			name:    "Fail removing anchors of removed versions",
+			pattern: "^DELETE FROM `audit_chain_anchors`",
+			change:  purge,
+		},
+		{
+			name:    "Fail loading anchors",
+			purged:  true,
+			pattern: "^SELECT \\* FROM `audit_chain_anchors`",
+			change: func(db *gorm.DB, player *PlayerWithRetention) error {
+				_, err := Verify(context.Background(), db, player)
+				return err
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, err := createDatabase()
+			if err != nil {
+				t.Errorf("createDatabase() error = %v", err)
+				return
+			}
+
+			err = db.AutoMigrate(PlayerWithRetention{})
+			if err != nil {
+				t.Errorf("AutoMigrate() error = %v", err)
+				return
+			}
+
+			player := &PlayerWithRetention{Name: "teste", policy: RetentionPolicy{KeepVersions: 1}}
+			db.Create(player)
+			for i := 0; i < 3; i++ {
+				player.Name = "teste atualizado"
+				db.Updates(player)
+			}
+			if tt.purged {
+				if err = purge(db, player); err != nil {
+					t.Errorf("Purge() error = %v", err)
+					return
+				}
+			}
+
+			failOn(db, tt.pattern)
+			err = tt.change(db, player)
+			if !errors.Is(err, errFailingStatement) {
+				t.Errorf("change() error = %v, want %v", err, errFailingStatement)
+			}
+		})
+	}
+}
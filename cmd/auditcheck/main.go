@@ -0,0 +1,92 @@
+// Command auditcheck scans auditable tables of a SQLite database and reports
+// inconsistent versions, optionally repairing the ones that can be fixed deterministically.
+//
+//	auditcheck -dsn audit.db -table companies -table players [-repair]
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	audit "github.com/meganewsopensource/megagormaudit"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+	"io"
+	"os"
+	"strings"
+)
+
+type tables []string
+
+func (t *tables) String() string {
+	return strings.Join(*t, ",")
+}
+
+func (t *tables) Set(value string) error {
+	*t = append(*t, value)
+	return nil
+}
+
+// exit terminates the command, replaced by the tests.
+var exit = os.Exit
+
+func main() {
+	exit(run(os.Args[1:], os.Stdout))
+}
+
+// run scans the tables given by args, writing the inconsistencies found to stdout, and returns the exit code.
+func run(args []string, stdout io.Writer) int {
+	var (
+		dsn    string
+		names  tables
+		repair bool
+	)
+	flags := flag.NewFlagSet("auditcheck", flag.ContinueOnError)
+	flags.StringVar(&dsn, "dsn", "", "SQLite data source name")
+	flags.Var(&names, "table", "auditable table to scan (repeatable)")
+	flags.BoolVar(&repair, "repair", false, "repair the inconsistencies that can be fixed deterministically")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	if dsn == "" || len(names) == 0 {
+		flags.Usage()
+		return 2
+	}
+
+	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	check := audit.CheckTable
+	if repair {
+		check = audit.RepairTable
+	}
+
+	unrepaired := 0
+	for _, table := range names {
+		inconsistencies, err := check(context.Background(), db, table)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", table, err)
+			return 1
+		}
+
+		for _, inconsistency := range inconsistencies {
+			status := ""
+			if inconsistency.Repaired {
+				status = " (repaired)"
+			} else {
+				unrepaired++
+			}
+			fmt.Fprintf(stdout, "%s id=%d: %s%s\n", table, inconsistency.ID, inconsistency.Issue, status)
+		}
+	}
+
+	if unrepaired > 0 {
+		return 1
+	}
+	return 0
+}
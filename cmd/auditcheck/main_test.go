@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	audit "github.com/meganewsopensource/megagormaudit"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+type Player struct {
+	audit.AuditableModel
+	Name string
+}
+
+func TestRun(t *testing.T) {
+
+	tests := []struct {
+		name       string
+		corrupt    string
+		args       []string
+		wantCode   int
+		wantOutput string
+	}{
+		{
+			name:     "Success, consistent table",
+			args:     []string{"-table", "players"},
+			wantCode: 0,
+		},
+		{
+			name:       "Multiple live versions",
+			corrupt:    "UPDATE players SET deleted_at = 0 WHERE id IN (1, 2)",
+			args:       []string{"-table", "players"},
+			wantCode:   1,
+			wantOutput: "players id=1: multiple live versions\nplayers id=2: multiple live versions\n",
+		},
+		{
+			name:       "Success, repair multiple live versions",
+			corrupt:    "UPDATE players SET deleted_at = 0 WHERE id IN (1, 2)",
+			args:       []string{"-table", "players", "-repair"},
+			wantCode:   0,
+			wantOutput: "players id=1: multiple live versions (repaired)\nplayers id=2: multiple live versions (repaired)\n",
+		},
+		{
+			name:     "Missing table",
+			args:     []string{"-table", "companies"},
+			wantCode: 1,
+		},
+		{
+			name:     "Missing tables flag",
+			args:     []string{},
+			wantCode: 2,
+		},
+		{
+			name:     "Unknown flag",
+			args:     []string{"-table", "players", "-force"},
+			wantCode: 2,
+		},
+		{
+			name:     "Database that can't be opened",
+			args:     []string{"-dsn", filepath.Join(t.TempDir(), "missing", "audit.db"), "-table", "players"},
+			wantCode: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dsn := filepath.Join(t.TempDir(), "audit.db")
+			db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{Logger: logger.Discard})
+			if err != nil {
+				t.Errorf("Open() error = %v", err)
+				return
+			}
+			err = db.Use(audit.MegaGormAuditPlugin{})
+			if err != nil {
+				t.Errorf("Use() error = %v", err)
+				return
+			}
+
+			err = db.AutoMigrate(Player{})
+			if err != nil {
+				t.Errorf("AutoMigrate() error = %v", err)
+				return
+			}
+
+			player := &Player{Name: "teste"}
+			db.Create(player)
+			for _, name := range []string{"teste atualizado", "teste atualizado de novo"} {
+				player.Name = name
+				if err = db.Updates(player).Error; err != nil {
+					t.Errorf("Updates() error = %v", err)
+					return
+				}
+			}
+			if tt.corrupt != "" {
+				if err = db.Scopes(audit.AllowBypass).Exec(tt.corrupt).Error; err != nil {
+					t.Errorf("Exec() error = %v", err)
+					return
+				}
+			}
+
+			args := tt.args
+			if len(args) > 0 && args[0] != "-dsn" {
+				args = append([]string{"-dsn", dsn}, args...)
+			}
+
+			var output bytes.Buffer
+			if code := run(args, &output); code != tt.wantCode {
+				t.Errorf("run() = %d, want %d", code, tt.wantCode)
+			}
+			if output.String() != tt.wantOutput {
+				t.Errorf("output = %q, want %q", output.String(), tt.wantOutput)
+			}
+		})
+	}
+}
+
+func TestMain_exitCode(t *testing.T) {
+	args, code := os.Args, -1
+	defer func() {
+		os.Args, exit = args, os.Exit
+	}()
+	os.Args = []string{"auditcheck"}
+	exit = func(c int) {
+		code = c
+	}
+
+	main()
+	if code != 2 {
+		t.Errorf("exit code = %d, want 2", code)
+	}
+}
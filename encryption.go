@@ -0,0 +1,120 @@
+package MegaGormAudit
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"gorm.io/gorm/schema"
+	"io"
+	"reflect"
+	"strings"
+)
+
+const encryptedPrefix = "enc:"
+
+var (
+	ErrNoEncrypter           = errors.New("megagormaudit: fields tagged with audit:\"encrypt\" require the plugin Encrypter")
+	ErrUnsupportedEncryption = errors.New("megagormaudit: only string fields can be encrypted")
+)
+
+// Encrypter encrypts the fields tagged with audit:"encrypt" when their version is superseded,
+// with a key kept apart from the database credentials.
+type Encrypter interface {
+	Encrypt(plaintext []byte) ([]byte, error)
+	Decrypt(ciphertext []byte) ([]byte, error)
+}
+
+type aesEncrypter struct {
+	aead   cipher.AEAD
+	random io.Reader //source of the nonces
+}
+
+// NewAESEncrypter returns an Encrypter using AES-GCM with key, of 16, 24 or 32 bytes.
+func NewAESEncrypter(key []byte) (Encrypter, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	aead, _ := cipher.NewGCM(block) //GCM always supports the AES block size
+	return aesEncrypter{aead: aead, random: rand.Reader}, nil
+}
+
+func (e aesEncrypter) Encrypt(plaintext []byte) ([]byte, error) {
+	nonce := make([]byte, e.aead.NonceSize())
+	if _, err := io.ReadFull(e.random, nonce); err != nil {
+		return nil, err
+	}
+	return e.aead.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+func (e aesEncrypter) Decrypt(ciphertext []byte) ([]byte, error) {
+	if len(ciphertext) < e.aead.NonceSize() {
+		return nil, errors.New("megagormaudit: ciphertext too short")
+	}
+	nonce, ciphertext := ciphertext[:e.aead.NonceSize()], ciphertext[e.aead.NonceSize():]
+	return e.aead.Open(nil, nonce, ciphertext, nil)
+}
+
+// encryptValue returns the value of a field tagged with audit:"encrypt" stored in a superseded version.
+func (a MegaGormAuditPlugin) encryptValue(field *schema.Field, value interface{}) (interface{}, error) {
+	if a.Encrypter == nil {
+		return nil, ErrNoEncrypter
+	}
+
+	rv := reflect.Indirect(reflect.ValueOf(value))
+	if !rv.IsValid() {
+		return value, nil
+	}
+	if rv.Kind() != reflect.String {
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedEncryption, field.Name)
+	}
+
+	ciphertext, err := a.Encrypter.Encrypt([]byte(rv.String()))
+	if err != nil {
+		return nil, err
+	}
+	return encryptedPrefix + base64.StdEncoding.EncodeToString(ciphertext), nil
+}
+
+// decryptVersions decrypts the encrypted fields of versions, a slice of the auditable schema model.
+// Live versions are never encrypted, so their values are kept even with the encrypted prefix.
+func (a MegaGormAuditPlugin) decryptVersions(ctx context.Context, auditSchema *schema.Schema, versions reflect.Value) error {
+	deletedAt := auditSchema.LookUpField("deleted_at")
+	for _, field := range auditSchema.Fields {
+		if !hasAuditTag(field, "encrypt") {
+			continue
+		}
+
+		for i := 0; i < versions.Len(); i++ {
+			version := reflect.Indirect(versions.Index(i))
+			if _, live := deletedAt.ValueOf(ctx, version); live {
+				continue
+			}
+
+			value, _ := field.ValueOf(ctx, version)
+			rv := reflect.Indirect(reflect.ValueOf(value))
+			if !rv.IsValid() || rv.Kind() != reflect.String || !strings.HasPrefix(rv.String(), encryptedPrefix) {
+				continue
+			}
+			if a.Encrypter == nil {
+				return ErrNoEncrypter
+			}
+
+			ciphertext, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(rv.String(), encryptedPrefix))
+			if err != nil {
+				return err
+			}
+			plaintext, err := a.Encrypter.Decrypt(ciphertext)
+			if err != nil {
+				return err
+			}
+			reflect.Indirect(field.ReflectValueOf(ctx, version)).SetString(string(plaintext))
+		}
+	}
+	return nil
+}
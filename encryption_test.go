@@ -0,0 +1,322 @@
+package MegaGormAudit
+
+import (
+	"context"
+	"errors"
+	"gorm.io/gorm"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+type SecretNote struct {
+	AuditableModel
+	Title string
+	Body  string `audit:"encrypt"`
+}
+
+type SecretCounter struct {
+	AuditableModel
+	Title string
+	Count int `audit:"encrypt"`
+}
+
+type SecretDraft struct {
+	AuditableModel
+	Title string
+	Body  *string `audit:"encrypt"`
+}
+
+var errFailingEncrypter = errors.New("failing encrypter")
+
+type failingEncrypter struct{}
+
+func (failingEncrypter) Encrypt(plaintext []byte) ([]byte, error) {
+	return nil, errFailingEncrypter
+}
+
+func (failingEncrypter) Decrypt(ciphertext []byte) ([]byte, error) {
+	return nil, errFailingEncrypter
+}
+
+func TestAuditPlugin_Encryption(t *testing.T) {
+
+	encrypter, err := NewAESEncrypter([]byte("0123456789abcdef0123456789abcdef"))
+	if err != nil {
+		t.Errorf("NewAESEncrypter() error = %v", err)
+		return
+	}
+
+	tests := []struct {
+		name        string
+		encrypter   Encrypter
+		model       interface{}
+		change      func(db *gorm.DB, model interface{}) error
+		wantErr     error
+		successTest func(db *gorm.DB) bool
+	}{
+		{
+			name:      "Success, superseded version encrypted",
+			encrypter: encrypter,
+			model:     &SecretNote{Title: "note", Body: "secret"},
+			successTest: func(db *gorm.DB) bool {
+				var versions []SecretNote
+				db.Unscoped().Order("id").Find(&versions)
+				if len(versions) != 2 || !strings.HasPrefix(versions[0].Body, "enc:") || versions[1].Body != "secret" {
+					return false
+				}
+
+				var history []SecretNote
+				err := History(context.Background(), db, &versions[1], &history)
+				return err == nil && len(history) == 2 && history[0].Body == "secret" && history[0].Title == "note" &&
+					history[1].Body == "secret" && history[1].Title == "updated"
+			},
+		},
+		{
+			name:      "Success, deleted version encrypted",
+			encrypter: encrypter,
+			model:     &SecretNote{Title: "note", Body: "secret"},
+			change: func(db *gorm.DB, model interface{}) error {
+				return db.Delete(model).Error
+			},
+			successTest: func(db *gorm.DB) bool {
+				var versions []SecretNote
+				db.Unscoped().Find(&versions)
+				if len(versions) != 1 || versions[0].DeletedAt == 0 || !strings.HasPrefix(versions[0].Body, "enc:") {
+					return false
+				}
+
+				var history []SecretNote
+				err := History(context.Background(), db, &versions[0], &history)
+				return err == nil && len(history) == 1 && history[0].Body == "secret"
+			},
+		},
+		{
+			name:      "Success, empty field kept",
+			encrypter: encrypter,
+			model:     &SecretDraft{Title: "draft"},
+			successTest: func(db *gorm.DB) bool {
+				var versions []SecretDraft
+				db.Unscoped().Order("id").Find(&versions)
+				if len(versions) != 2 || versions[0].Body != nil {
+					return false
+				}
+
+				var history []SecretDraft
+				err := History(context.Background(), db, &versions[1], &history)
+				return err == nil && len(history) == 2 && history[0].Body == nil
+			},
+		},
+		{
+			name:  "Fail to delete without encrypter",
+			model: &SecretNote{Title: "note", Body: "secret"},
+			change: func(db *gorm.DB, model interface{}) error {
+				return db.Delete(model).Error
+			},
+			wantErr: ErrNoEncrypter,
+			successTest: func(db *gorm.DB) bool {
+				var versions []SecretNote
+				db.Find(&versions)
+				return len(versions) == 1 && versions[0].Body == "secret"
+			},
+		},
+		{
+			name:    "Fail without encrypter",
+			model:   &SecretNote{Title: "note", Body: "secret"},
+			wantErr: ErrNoEncrypter,
+			successTest: func(db *gorm.DB) bool {
+				var versions []SecretNote
+				db.Unscoped().Find(&versions)
+				return len(versions) == 1 && versions[0].Body == "secret"
+			},
+		},
+		{
+			name:      "Fail on field that isn't a string",
+			encrypter: encrypter,
+			model:     &SecretCounter{Title: "counter", Count: 1},
+			wantErr:   ErrUnsupportedEncryption,
+			successTest: func(db *gorm.DB) bool {
+				var versions []SecretCounter
+				db.Unscoped().Find(&versions)
+				return len(versions) == 1
+			},
+		},
+		{
+			name:      "Fail on encrypter error",
+			encrypter: failingEncrypter{},
+			model:     &SecretNote{Title: "note", Body: "secret"},
+			wantErr:   errFailingEncrypter,
+			successTest: func(db *gorm.DB) bool {
+				var versions []SecretNote
+				db.Unscoped().Find(&versions)
+				return len(versions) == 1 && versions[0].Body == "secret"
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, err := createDatabaseWithPlugin(MegaGormAuditPlugin{Encrypter: tt.encrypter})
+			if err != nil {
+				t.Errorf("createDatabase() error = %v", err)
+				return
+			}
+
+			err = db.AutoMigrate(tt.model)
+			if err != nil {
+				t.Errorf("AutoMigrate() error = %v", err)
+				return
+			}
+
+			db.Create(tt.model)
+			change := tt.change
+			if change == nil {
+				change = func(db *gorm.DB, model interface{}) error {
+					return db.Model(model).Update("title", "updated").Error
+				}
+			}
+			err = change(db, tt.model)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("change() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+
+			if !tt.successTest(db) {
+				t.Errorf("successTest() = false, want true")
+			}
+
+			tampered, err := Verify(context.Background(), db, tt.model)
+			if err != nil || len(tampered) > 0 {
+				t.Errorf("Verify() = %v, %v, want no tampered versions", tampered, err)
+			}
+		})
+	}
+}
+
+func TestHistory_Decryption(t *testing.T) {
+
+	encrypter, err := NewAESEncrypter([]byte("0123456789abcdef"))
+	if err != nil {
+		t.Errorf("NewAESEncrypter() error = %v", err)
+		return
+	}
+
+	tests := []struct {
+		name      string
+		encrypter Encrypter
+		body      string
+		live      bool
+		wantErr   bool
+	}{
+		{
+			name:      "Success, value that isn't encrypted",
+			encrypter: encrypter,
+			body:      "plain",
+		},
+		{
+			name:      "Success, live value with the encrypted prefix",
+			encrypter: encrypter,
+			body:      "enc:!!!",
+			live:      true,
+		},
+		{
+			name:    "Fail without encrypter",
+			body:    "enc:c2VjcmV0",
+			wantErr: true,
+		},
+		{
+			name:      "Fail on invalid encoding",
+			encrypter: encrypter,
+			body:      "enc:!!!",
+			wantErr:   true,
+		},
+		{
+			name:      "Fail on invalid ciphertext",
+			encrypter: encrypter,
+			body:      "enc:c2VjcmV0",
+			wantErr:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, err := createDatabaseWithPlugin(MegaGormAuditPlugin{Encrypter: tt.encrypter})
+			if err != nil {
+				t.Errorf("createDatabase() error = %v", err)
+				return
+			}
+
+			err = db.AutoMigrate(SecretNote{})
+			if err != nil {
+				t.Errorf("AutoMigrate() error = %v", err)
+				return
+			}
+
+			note := &SecretNote{Title: "note", Body: "secret"}
+			db.Create(note)
+			deletedAt := 1
+			if tt.live {
+				deletedAt = 0
+			}
+			db.Scopes(AllowBypass).Exec("UPDATE secret_notes SET body = ?, deleted_at = ?", tt.body, deletedAt)
+
+			var history []SecretNote
+			err = History(context.Background(), db, note, &history)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("History() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !tt.wantErr && (len(history) != 1 || history[0].Body != tt.body) {
+				t.Errorf("History() = %+v, want %s", history, tt.body)
+			}
+		})
+	}
+}
+
+func TestNewAESEncrypter(t *testing.T) {
+
+	tests := []struct {
+		name    string
+		key     []byte
+		wantErr bool
+	}{
+		{
+			name: "Success",
+			key:  []byte("0123456789abcdef"),
+		},
+		{
+			name:    "Fail on invalid key size",
+			key:     []byte("key"),
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			encrypter, err := NewAESEncrypter(tt.key)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("NewAESEncrypter() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr {
+				return
+			}
+
+			ciphertext, err := encrypter.Encrypt([]byte("secret"))
+			if err != nil {
+				t.Errorf("Encrypt() error = %v", err)
+				return
+			}
+			plaintext, err := encrypter.Decrypt(ciphertext)
+			if err != nil || string(plaintext) != "secret" {
+				t.Errorf("Decrypt() = %s, %v, want secret", plaintext, err)
+			}
+			if _, err := encrypter.Decrypt([]byte("short")); err == nil {
+				t.Errorf("Decrypt() error = nil, want error")
+			}
+
+			failing := encrypter.(aesEncrypter)
+			failing.random = iotest.ErrReader(errFailingEncrypter)
+			if _, err := failing.Encrypt([]byte("secret")); !errors.Is(err, errFailingEncrypter) {
+				t.Errorf("Encrypt() error = %v, want %v", err, errFailingEncrypter)
+			}
+		})
+	}
+}
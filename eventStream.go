@@ -0,0 +1,267 @@
+package MegaGormAudit
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"gorm.io/gorm"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+type OverflowPolicy int
+
+const (
+	OverflowDrop  OverflowPolicy = iota //drops events when the subscription buffer is full, counting them in Dropped
+	OverflowBlock                       //waits for the subscriber, blocking the commit that delivers the events
+)
+
+var ErrNoEventStream = errors.New("megagormaudit: plugin has no EventStream")
+
+var savepointStatement = regexp.MustCompile(`(?i)^\s*(SAVEPOINT|ROLLBACK\s+TO(?:\s+SAVEPOINT)?|RELEASE(?:\s+SAVEPOINT)?)\s+([^\s;]+)\s*;?\s*$`)
+
+// EventFilter selects the events delivered to a subscription. Empty fields match every event.
+type EventFilter struct {
+	Entities   []string //tables of the auditable models
+	Operations []string //EventCreate, EventUpdate and/or EventDelete
+}
+
+func (f EventFilter) match(event AuditEvent) bool {
+	return matchAny(f.Entities, event.Entity) && matchAny(f.Operations, event.Operation)
+}
+
+func matchAny(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return len(values) == 0
+}
+
+// EventStream delivers audit events to in-process subscribers after the transactions of the changes commit.
+// Events of rolled back transactions and savepoints are never delivered.
+type EventStream struct {
+	mu            sync.RWMutex
+	subscriptions map[*Subscription]bool
+}
+
+func NewEventStream() *EventStream {
+	return &EventStream{subscriptions: map[*Subscription]bool{}}
+}
+
+// Subscription receives the events of an EventStream matching its filter.
+type Subscription struct {
+	stream    *EventStream
+	filter    EventFilter
+	policy    OverflowPolicy
+	events    chan AuditEvent
+	done      chan struct{}
+	dropped   atomic.Uint64
+	closeOnce sync.Once
+}
+
+// Subscribe returns a subscription to the events matching filter, buffering up to buffer events
+// and handling a full buffer with policy.
+func (s *EventStream) Subscribe(filter EventFilter, buffer int, policy OverflowPolicy) *Subscription {
+	subscription := &Subscription{
+		stream: s,
+		filter: filter,
+		policy: policy,
+		events: make(chan AuditEvent, buffer),
+		done:   make(chan struct{}),
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.subscriptions[subscription] = true
+	return subscription
+}
+
+// Subscribe subscribes to the plugin EventStream.
+func (a MegaGormAuditPlugin) Subscribe(filter EventFilter, buffer int, policy OverflowPolicy) (*Subscription, error) {
+	if a.Events == nil {
+		return nil, ErrNoEventStream
+	}
+	return a.Events.Subscribe(filter, buffer, policy), nil
+}
+
+// Events returns the channel of the subscription events, closed by Close.
+func (s *Subscription) Events() <-chan AuditEvent {
+	return s.events
+}
+
+// Dropped returns the number of events dropped because the buffer was full.
+func (s *Subscription) Dropped() uint64 {
+	return s.dropped.Load()
+}
+
+// Close stops the delivery of events and closes the events channel.
+func (s *Subscription) Close() {
+	s.closeOnce.Do(func() {
+		close(s.done)
+
+		s.stream.mu.Lock()
+		delete(s.stream.subscriptions, s)
+		s.stream.mu.Unlock()
+
+		close(s.events)
+	})
+}
+
+func (s *Subscription) deliver(event AuditEvent) {
+	if s.policy == OverflowBlock {
+		select {
+		case s.events <- event:
+		case <-s.done:
+		}
+		return
+	}
+
+	select {
+	case s.events <- event:
+	default:
+		s.dropped.Add(1)
+	}
+}
+
+func (s *EventStream) publish(events ...AuditEvent) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, event := range events {
+		for subscription := range s.subscriptions {
+			if subscription.filter.match(event) {
+				subscription.deliver(event)
+			}
+		}
+	}
+}
+
+// queue delivers event when the transaction of tx commits, or immediately when tx is not in a transaction.
+func (s *EventStream) queue(tx *gorm.DB, event AuditEvent) {
+	if pending, ok := streamTxOf(tx.Statement.ConnPool); ok {
+		pending.add(event)
+		return
+	}
+	s.publish(event)
+}
+
+// streamPool wraps the connection pool of the database so the transactions it begins deliver their events on commit.
+type streamPool struct {
+	gorm.ConnPool
+	stream *EventStream
+}
+
+func (p *streamPool) BeginTx(ctx context.Context, opts *sql.TxOptions) (gorm.ConnPool, error) {
+	var tx gorm.ConnPool
+	var err error
+	switch beginner := p.ConnPool.(type) {
+	case gorm.TxBeginner:
+		tx, err = beginner.BeginTx(ctx, opts)
+	case gorm.ConnPoolBeginner:
+		tx, err = beginner.BeginTx(ctx, opts)
+	default:
+		err = gorm.ErrInvalidTransaction
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &streamTx{ConnPool: tx, pool: p}, nil
+}
+
+func (p *streamPool) GetDBConn() (*sql.DB, error) {
+	if db, ok := p.ConnPool.(*sql.DB); ok {
+		return db, nil
+	}
+	if connector, ok := p.ConnPool.(gorm.GetDBConnector); ok {
+		return connector.GetDBConn()
+	}
+	return nil, gorm.ErrInvalidDB
+}
+
+// streamTx holds the events of a transaction until it commits, discarding the events of rolled back savepoints.
+type streamTx struct {
+	gorm.ConnPool
+	pool       *streamPool
+	mu         sync.Mutex
+	events     []AuditEvent
+	savepoints map[string]int //savepoint names with the number of events queued before them
+}
+
+func (t *streamTx) add(event AuditEvent) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.events = append(t.events, event)
+}
+
+// streamTxOf returns the streamTx of a transaction connection pool, which is wrapped when statements are prepared.
+func streamTxOf(pool gorm.ConnPool) (*streamTx, bool) {
+	if prepared, ok := pool.(*gorm.PreparedStmtTX); ok {
+		pool = prepared.Tx
+	}
+	tx, ok := pool.(*streamTx)
+	return tx, ok
+}
+
+// trackSavepoints follows the savepoints created, rolled back and released in the transaction of a raw statement,
+// as the SQL of prepared statements doesn't reach the streamTx.
+func trackSavepoints(db *gorm.DB) {
+	if tx, ok := streamTxOf(db.Statement.ConnPool); ok && db.Error == nil {
+		tx.trackSavepoint(db.Statement.SQL.String())
+	}
+}
+
+func (t *streamTx) trackSavepoint(query string) {
+	match := savepointStatement.FindStringSubmatch(query)
+	if match == nil {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.savepoints == nil {
+		t.savepoints = map[string]int{}
+	}
+
+	name := match[2]
+	switch strings.Fields(strings.ToUpper(match[1]))[0] {
+	case "ROLLBACK":
+		if queued, ok := t.savepoints[name]; ok {
+			t.events = t.events[:queued]
+		}
+	case "RELEASE":
+		delete(t.savepoints, name)
+	default:
+		t.savepoints[name] = len(t.events)
+	}
+}
+
+func (t *streamTx) Commit() error {
+	if err := t.ConnPool.(gorm.TxCommitter).Commit(); err != nil {
+		return err
+	}
+
+	t.mu.Lock()
+	events := t.events
+	t.events = nil
+	t.mu.Unlock()
+	t.pool.stream.publish(events...)
+	return nil
+}
+
+func (t *streamTx) Rollback() error {
+	t.mu.Lock()
+	t.events = nil
+	t.mu.Unlock()
+	return t.ConnPool.(gorm.TxCommitter).Rollback()
+}
+
+func (t *streamTx) StmtContext(ctx context.Context, stmt *sql.Stmt) *sql.Stmt {
+	return t.ConnPool.(gorm.Tx).StmtContext(ctx, stmt)
+}
+
+func (t *streamTx) GetDBConn() (*sql.DB, error) {
+	return t.pool.GetDBConn()
+}
@@ -0,0 +1,334 @@
+package MegaGormAudit
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"gorm.io/gorm"
+	"reflect"
+	"testing"
+)
+
+func TestAuditPlugin_EventStream(t *testing.T) {
+
+	tests := []struct {
+		name        string
+		filter      EventFilter
+		change      func(t *testing.T, db *gorm.DB, account *Account, subscription *Subscription) error
+		wantErr     error
+		wantEvents  []string
+		wantDropped uint64
+	}{
+		{
+			name: "Success, events delivered after commit",
+			change: func(t *testing.T, db *gorm.DB, account *Account, subscription *Subscription) error {
+				return db.Transaction(func(tx *gorm.DB) error {
+					account.Balance = 20
+					if err := tx.Updates(account).Error; err != nil {
+						return err
+					}
+					if len(subscription.Events()) != 0 {
+						t.Errorf("events delivered before commit")
+					}
+					return tx.Create(&Account{Owner: "bob"}).Error
+				})
+			},
+			wantEvents: []string{"accounts update 1", "accounts create 3"},
+		},
+		{
+			name: "Success, no events of rolled back savepoints",
+			change: func(t *testing.T, db *gorm.DB, account *Account, subscription *Subscription) error {
+				return db.Transaction(func(tx *gorm.DB) error {
+					err := tx.Transaction(func(tx *gorm.DB) error {
+						account.Balance = 20
+						if err := tx.Updates(account).Error; err != nil {
+							return err
+						}
+						return errRolledBack
+					})
+					if !errors.Is(err, errRolledBack) {
+						t.Errorf("Transaction() error = %v, want %v", err, errRolledBack)
+					}
+					return tx.Delete(&Account{AuditableModel: AuditableModel{ID: 1}}).Error
+				})
+			},
+			wantEvents: []string{"accounts delete 1"},
+		},
+		{
+			name: "Success, events of prepared statement transactions",
+			change: func(t *testing.T, db *gorm.DB, account *Account, subscription *Subscription) error {
+				prepared := db.Session(&gorm.Session{PrepareStmt: true})
+				account.Balance = 20
+				if err := prepared.Updates(account).Error; err != nil {
+					return err
+				}
+				return prepared.Transaction(func(tx *gorm.DB) error {
+					err := tx.Transaction(func(tx *gorm.DB) error {
+						if err := tx.Create(&Account{Owner: "bob"}).Error; err != nil {
+							return err
+						}
+						if len(subscription.Events()) != 1 {
+							t.Errorf("events delivered before commit")
+						}
+						return errRolledBack
+					})
+					if !errors.Is(err, errRolledBack) {
+						t.Errorf("Transaction() error = %v, want %v", err, errRolledBack)
+					}
+					return tx.Create(&Account{Owner: "carol"}).Error
+				})
+			},
+			wantEvents: []string{"accounts update 1", "accounts create 3"},
+		},
+		{
+			name: "Fail on rolled back transaction without events",
+			change: func(t *testing.T, db *gorm.DB, account *Account, subscription *Subscription) error {
+				return db.Transaction(func(tx *gorm.DB) error {
+					account.Balance = 20
+					if err := tx.Updates(account).Error; err != nil {
+						return err
+					}
+					return errRolledBack
+				})
+			},
+			wantErr: errRolledBack,
+		},
+		{
+			name:   "Success, only events matching the filter",
+			filter: EventFilter{Entities: []string{"accounts"}, Operations: []string{EventDelete}},
+			change: func(t *testing.T, db *gorm.DB, account *Account, subscription *Subscription) error {
+				account.Balance = 20
+				if err := db.Updates(account).Error; err != nil {
+					return err
+				}
+				return db.Delete(account).Error
+			},
+			wantEvents: []string{"accounts delete 1"},
+		},
+		{
+			name: "Success, events dropped when the buffer is full",
+			change: func(t *testing.T, db *gorm.DB, account *Account, subscription *Subscription) error {
+				for _, owner := range []string{"bob", "carol", "dave"} {
+					if err := db.Create(&Account{Owner: owner}).Error; err != nil {
+						return err
+					}
+				}
+				return nil
+			},
+			wantEvents:  []string{"accounts create 2", "accounts create 3"},
+			wantDropped: 1,
+		},
+		{
+			name: "Success, events kept after released savepoint",
+			change: func(t *testing.T, db *gorm.DB, account *Account, subscription *Subscription) error {
+				return db.Transaction(func(tx *gorm.DB) error {
+					if err := tx.SavePoint("balance").Error; err != nil {
+						return err
+					}
+					account.Balance = 20
+					if err := tx.Updates(account).Error; err != nil {
+						return err
+					}
+					if err := tx.Exec("SELECT 1").Error; err != nil {
+						return err
+					}
+					return tx.Exec("RELEASE SAVEPOINT balance").Error
+				})
+			},
+			wantEvents: []string{"accounts update 1"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stream := NewEventStream()
+			db, err := createDatabaseWithPlugin(MegaGormAuditPlugin{Events: stream})
+			if err != nil {
+				t.Errorf("createDatabase() error = %v", err)
+				return
+			}
+
+			err = db.AutoMigrate(Account{})
+			if err != nil {
+				t.Errorf("AutoMigrate() error = %v", err)
+				return
+			}
+
+			account := &Account{Owner: "alice", Balance: 10}
+			if err := db.Create(account).Error; err != nil {
+				t.Errorf("Create() error = %v", err)
+				return
+			}
+
+			subscription := stream.Subscribe(tt.filter, 2, OverflowDrop)
+			err = tt.change(t, db, account, subscription)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("change() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+
+			subscription.Close()
+			events := []string{}
+			for event := range subscription.Events() {
+				id := event.EntityID
+				if event.Operation == EventCreate {
+					id = event.NewVersionID
+				}
+				events = append(events, fmt.Sprint(event.Entity, " ", event.Operation, " ", id))
+			}
+			if tt.wantEvents == nil {
+				tt.wantEvents = []string{}
+			}
+			if !reflect.DeepEqual(events, tt.wantEvents) {
+				t.Errorf("events = %v, want %v", events, tt.wantEvents)
+			}
+			if subscription.Dropped() != tt.wantDropped {
+				t.Errorf("Dropped() = %v, want %v", subscription.Dropped(), tt.wantDropped)
+			}
+		})
+	}
+}
+
+func TestEventStream_Block(t *testing.T) {
+	stream := NewEventStream()
+	db, err := createDatabaseWithPlugin(MegaGormAuditPlugin{Events: stream})
+	if err != nil {
+		t.Errorf("createDatabase() error = %v", err)
+		return
+	}
+
+	err = db.AutoMigrate(Account{})
+	if err != nil {
+		t.Errorf("AutoMigrate() error = %v", err)
+		return
+	}
+
+	subscription := stream.Subscribe(EventFilter{}, 0, OverflowBlock)
+	received := make(chan int)
+	go func() {
+		count := 0
+		for range subscription.Events() {
+			count++
+		}
+		received <- count
+	}()
+
+	for _, owner := range []string{"alice", "bob", "carol"} {
+		if err := db.Create(&Account{Owner: owner}).Error; err != nil {
+			t.Errorf("Create() error = %v", err)
+			return
+		}
+	}
+	subscription.Close()
+
+	if count := <-received; count != 3 || subscription.Dropped() != 0 {
+		t.Errorf("received = %v, dropped = %v, want 3, 0", count, subscription.Dropped())
+	}
+}
+
+func TestAuditPlugin_Subscribe(t *testing.T) {
+	_, err := MegaGormAuditPlugin{}.Subscribe(EventFilter{}, 1, OverflowDrop)
+	if !errors.Is(err, ErrNoEventStream) {
+		t.Errorf("Subscribe() error = %v, want %v", err, ErrNoEventStream)
+	}
+
+	subscription, err := MegaGormAuditPlugin{Events: NewEventStream()}.Subscribe(EventFilter{}, 1, OverflowDrop)
+	if err != nil || subscription == nil {
+		t.Errorf("Subscribe() = %v, %v, want subscription", subscription, err)
+	}
+}
+
+func TestSubscription_deliverClosed(t *testing.T) {
+	subscription := &Subscription{policy: OverflowBlock, events: make(chan AuditEvent), done: make(chan struct{})}
+	close(subscription.done)
+
+	subscription.deliver(AuditEvent{Entity: "accounts"})
+	if len(subscription.events) != 0 || subscription.Dropped() != 0 {
+		t.Errorf("events = %v, dropped = %v, want event discarded", len(subscription.events), subscription.Dropped())
+	}
+}
+
+func TestStreamPool(t *testing.T) {
+
+	tests := []struct {
+		name         string
+		pool         func(sqlDB *sql.DB) gorm.ConnPool
+		canceled     bool
+		wantBeginErr bool
+		wantDBErr    bool
+	}{
+		{
+			name: "Success, database",
+			pool: func(sqlDB *sql.DB) gorm.ConnPool {
+				return sqlDB
+			},
+		},
+		{
+			name: "Success, prepared statements",
+			pool: func(sqlDB *sql.DB) gorm.ConnPool {
+				return gorm.NewPreparedStmtDB(sqlDB)
+			},
+		},
+		{
+			name: "Fail beginning transaction",
+			pool: func(sqlDB *sql.DB) gorm.ConnPool {
+				return sqlDB
+			},
+			canceled:     true,
+			wantBeginErr: true,
+		},
+		{
+			name: "Fail on pool without transactions",
+			pool: func(sqlDB *sql.DB) gorm.ConnPool {
+				return failingConn{ConnPool: sqlDB}
+			},
+			wantBeginErr: true,
+			wantDBErr:    true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, err := createDatabase()
+			if err != nil {
+				t.Errorf("createDatabase() error = %v", err)
+				return
+			}
+			sqlDB, err := db.DB()
+			if err != nil {
+				t.Errorf("DB() error = %v", err)
+				return
+			}
+
+			pool := &streamPool{ConnPool: tt.pool(sqlDB), stream: NewEventStream()}
+			conn, err := pool.GetDBConn()
+			if (err != nil) != tt.wantDBErr || (err == nil && conn != sqlDB) {
+				t.Errorf("GetDBConn() = %v, %v, wantErr %v", conn, err, tt.wantDBErr)
+				return
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			if tt.canceled {
+				cancel()
+			}
+			defer cancel()
+			tx, err := pool.BeginTx(ctx, nil)
+			if (err != nil) != tt.wantBeginErr {
+				t.Errorf("BeginTx() error = %v, wantErr %v", err, tt.wantBeginErr)
+				return
+			}
+			if tt.wantBeginErr {
+				return
+			}
+
+			if conn, err := tx.(*streamTx).GetDBConn(); err != nil || conn != sqlDB {
+				t.Errorf("streamTx GetDBConn() = %v, %v, want %v", conn, err, sqlDB)
+			}
+			if err := tx.(gorm.TxCommitter).Commit(); err != nil {
+				t.Errorf("Commit() error = %v", err)
+			}
+			if err := tx.(gorm.TxCommitter).Commit(); err == nil {
+				t.Errorf("Commit() error = nil, want error committing twice")
+			}
+		})
+	}
+}
@@ -0,0 +1,87 @@
+package MegaGormAudit
+
+import (
+	"context"
+	"gorm.io/gorm/schema"
+	"reflect"
+	"strings"
+)
+
+// ImmutableFieldsError is returned by updates changing fields tagged with audit:"immutable".
+type ImmutableFieldsError struct {
+	Fields []string //names of the changed immutable fields
+}
+
+func (e *ImmutableFieldsError) Error() string {
+	return "megagormaudit: immutable fields can't be changed: " + strings.Join(e.Fields, ", ")
+}
+
+// hasOnlyFields reports whether fields of the schema are tagged with audit:"only".
+func hasOnlyFields(auditSchema *schema.Schema) bool {
+	for _, field := range auditSchema.Fields {
+		if hasAuditTag(field, "only") {
+			return true
+		}
+	}
+	return false
+}
+
+func isAuditableModelField(field *schema.Field) bool {
+	return len(field.BindNames) > 1 && field.BindNames[0] == "AuditableModel"
+}
+
+// versionedField reports whether changes to field create a new version. Fields tagged with audit:"-",
+// or not tagged with audit:"only" when the schema has such fields, are updated in place instead.
+func versionedField(auditSchema *schema.Schema, field *schema.Field) bool {
+	if isAuditableModelField(field) {
+		return true
+	}
+	if hasAuditTag(field, "-") {
+		return false
+	}
+	return !hasOnlyFields(auditSchema) || hasAuditTag(field, "only")
+}
+
+// inPlaceColumns returns the columns changed from previous to model when none of them creates a new version.
+func inPlaceColumns(ctx context.Context, auditSchema *schema.Schema, previous, model interface{}) []string {
+	previousValue := reflect.Indirect(reflect.ValueOf(previous))
+	modelValue := reflect.Indirect(reflect.ValueOf(model))
+
+	var columns []string
+	for _, field := range auditSchema.Fields {
+		if field.DBName == "" || isAuditableModelField(field) {
+			continue
+		}
+
+		old, _ := field.ValueOf(ctx, previousValue)
+		value, _ := field.ValueOf(ctx, modelValue)
+		if hashValue(old) == hashValue(value) {
+			continue
+		}
+		if versionedField(auditSchema, field) {
+			return nil
+		}
+		columns = append(columns, field.DBName)
+	}
+	return columns
+}
+
+// changedImmutableFields returns the names of the fields tagged with audit:"immutable" changed from previous to model.
+func changedImmutableFields(ctx context.Context, auditSchema *schema.Schema, previous, model interface{}) []string {
+	previousValue := reflect.Indirect(reflect.ValueOf(previous))
+	modelValue := reflect.Indirect(reflect.ValueOf(model))
+
+	var fields []string
+	for _, field := range auditSchema.Fields {
+		if !hasAuditTag(field, "immutable") {
+			continue
+		}
+
+		old, _ := field.ValueOf(ctx, previousValue)
+		value, _ := field.ValueOf(ctx, modelValue)
+		if hashValue(old) != hashValue(value) {
+			fields = append(fields, field.Name)
+		}
+	}
+	return fields
+}
@@ -0,0 +1,213 @@
+package MegaGormAudit
+
+import (
+	"context"
+	"errors"
+	"gorm.io/gorm"
+	"reflect"
+	"testing"
+)
+
+type Article struct {
+	AuditableModel
+	Title     string
+	ViewCount int `audit:"-"`
+}
+
+type Profile struct {
+	AuditableModel
+	Name     string `audit:"only"`
+	LastSeen string
+}
+
+type Contract struct {
+	AuditableModel
+	TaxID  string `audit:"immutable"`
+	Number string `audit:"immutable"`
+	Value  int
+}
+
+func TestAuditPlugin_FieldTags(t *testing.T) {
+
+	tests := []struct {
+		name         string
+		model        interface{}
+		pattern      string
+		update       func(db *gorm.DB, article *Article, profile *Profile) error
+		wantErr      error
+		wantVersions int
+	}{
+		{
+			name:  "Success, excluded field updated in place",
+			model: &Article{},
+			update: func(db *gorm.DB, article *Article, profile *Profile) error {
+				article.ViewCount = 5
+				return db.Updates(article).Error
+			},
+			wantVersions: 1,
+		},
+		{
+			name:  "Success, excluded field updated in place with update column",
+			model: &Article{},
+			update: func(db *gorm.DB, article *Article, profile *Profile) error {
+				return db.Model(article).UpdateColumn("view_count", 5).Error
+			},
+			wantVersions: 1,
+		},
+		{
+			name:  "Success, excluded field changed with other fields is versioned",
+			model: &Article{},
+			update: func(db *gorm.DB, article *Article, profile *Profile) error {
+				article.ViewCount = 5
+				article.Title = "updated"
+				return db.Updates(article).Error
+			},
+			wantVersions: 2,
+		},
+		{
+			name:  "Success, update without changes is versioned",
+			model: &Article{},
+			update: func(db *gorm.DB, article *Article, profile *Profile) error {
+				return db.Updates(article).Error
+			},
+			wantVersions: 2,
+		},
+		{
+			name:  "Success, field not tagged only updated in place",
+			model: &Profile{},
+			update: func(db *gorm.DB, article *Article, profile *Profile) error {
+				profile.LastSeen = "today"
+				return db.Updates(profile).Error
+			},
+			wantVersions: 1,
+		},
+		{
+			name:  "Success, field tagged only is versioned",
+			model: &Profile{},
+			update: func(db *gorm.DB, article *Article, profile *Profile) error {
+				profile.Name = "updated"
+				return db.Updates(profile).Error
+			},
+			wantVersions: 2,
+		},
+		{
+			name:    "Fail updating excluded field in place",
+			model:   &Article{},
+			pattern: "^UPDATE `articles`",
+			update: func(db *gorm.DB, article *Article, profile *Profile) error {
+				article.ViewCount = 5
+				return db.Updates(article).Error
+			},
+			wantErr:      errFailingStatement,
+			wantVersions: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, err := createDatabase()
+			if err != nil {
+				t.Errorf("createDatabase() error = %v", err)
+				return
+			}
+
+			err = db.AutoMigrate(Article{}, Profile{})
+			if err != nil {
+				t.Errorf("AutoMigrate() error = %v", err)
+				return
+			}
+
+			article := &Article{Title: "article"}
+			profile := &Profile{Name: "profile"}
+			db.Create(article)
+			db.Create(profile)
+
+			if tt.pattern != "" {
+				failOn(db, tt.pattern)
+			}
+			err = tt.update(db, article, profile)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("update() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+
+			var versions int64
+			db.Unscoped().Model(tt.model).Count(&versions)
+			if versions != int64(tt.wantVersions) {
+				t.Errorf("versions = %d, want %d", versions, tt.wantVersions)
+			}
+
+			tampered, err := Verify(context.Background(), db, tt.model)
+			if err != nil || len(tampered) > 0 {
+				t.Errorf("Verify() = %v, %v, want no tampered versions", tampered, err)
+			}
+		})
+	}
+}
+
+func TestAuditPlugin_ImmutableFields(t *testing.T) {
+
+	tests := []struct {
+		name       string
+		update     func(db *gorm.DB, contract *Contract) error
+		wantFields []string
+	}{
+		{
+			name: "Success, update without changes to immutable fields",
+			update: func(db *gorm.DB, contract *Contract) error {
+				contract.Value = 20
+				return db.Updates(contract).Error
+			},
+		},
+		{
+			name: "Fail on changed immutable fields",
+			update: func(db *gorm.DB, contract *Contract) error {
+				contract.TaxID = "changed"
+				contract.Number = "changed"
+				contract.Value = 20
+				return db.Updates(contract).Error
+			},
+			wantFields: []string{"TaxID", "Number"},
+		},
+		{
+			name: "Fail on immutable field changed with update column",
+			update: func(db *gorm.DB, contract *Contract) error {
+				return db.Model(contract).UpdateColumn("number", "changed").Error
+			},
+			wantFields: []string{"Number"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, err := createDatabase()
+			if err != nil {
+				t.Errorf("createDatabase() error = %v", err)
+				return
+			}
+
+			err = db.AutoMigrate(Contract{})
+			if err != nil {
+				t.Errorf("AutoMigrate() error = %v", err)
+				return
+			}
+
+			contract := &Contract{TaxID: "tax", Number: "number", Value: 10}
+			db.Create(contract)
+
+			err = tt.update(db, contract)
+			var immutableErr *ImmutableFieldsError
+			if errors.As(err, &immutableErr) {
+				if !reflect.DeepEqual(immutableErr.Fields, tt.wantFields) {
+					t.Errorf("Fields = %v, want %v", immutableErr.Fields, tt.wantFields)
+				}
+			} else if err != nil || tt.wantFields != nil {
+				t.Errorf("update() error = %v, want fields %v", err, tt.wantFields)
+			}
+
+			var versions int64
+			db.Unscoped().Model(&Contract{}).Count(&versions)
+			if wantVersions := map[bool]int64{true: 1, false: 2}[tt.wantFields != nil]; versions != wantVersions {
+				t.Errorf("versions = %d, want %d", versions, wantVersions)
+			}
+		})
+	}
+}
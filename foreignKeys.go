@@ -0,0 +1,136 @@
+package MegaGormAudit
+
+import (
+	"errors"
+	"fmt"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+	"reflect"
+)
+
+// ForeignKeyPolicy defines what happens to live rows referencing a version that is superseded.
+type ForeignKeyPolicy int
+
+const (
+	ForeignKeyKeep   ForeignKeyPolicy = iota //referencing rows keep pointing at the superseded version
+	ForeignKeyRemap                          //referencing rows are pointed at the new version
+	ForeignKeyReject                         //the update fails with ErrReferencedVersion
+)
+
+var ErrReferencedVersion = errors.New("megagormaudit: superseded version is referenced by live rows")
+
+// reference is a foreign key of a child model pointing at the ID of an auditable model.
+type reference struct {
+	child      *schema.Schema
+	foreignKey *schema.Field
+}
+
+// references returns the has one and has many relationships of the auditable schema and the
+// belongs to relationships of the plugin ReferencingModels pointing at it.
+func (a MegaGormAuditPlugin) references(db *gorm.DB, auditSchema *schema.Schema) ([]reference, error) {
+	var references []reference
+	seen := map[string]bool{}
+	add := func(rel *schema.Relationship, child *schema.Schema) {
+		for _, ref := range rel.References {
+			key := child.Table + "." + ref.ForeignKey.DBName
+			if ref.PrimaryKey != nil && ref.PrimaryKey.DBName == "id" && !seen[key] {
+				seen[key] = true
+				references = append(references, reference{child: child, foreignKey: ref.ForeignKey})
+			}
+		}
+	}
+
+	for _, rel := range append(auditSchema.Relationships.HasOne, auditSchema.Relationships.HasMany...) {
+		if rel.Name != "AuditParent" {
+			add(rel, rel.FieldSchema)
+		}
+	}
+
+	for _, model := range a.ReferencingModels {
+		stmt := &gorm.Statement{DB: db}
+		if err := stmt.Parse(model); err != nil {
+			return nil, err
+		}
+		for _, rel := range stmt.Schema.Relationships.BelongsTo {
+			if rel.FieldSchema.Table == auditSchema.Table {
+				add(rel, stmt.Schema)
+			}
+		}
+	}
+	return references, nil
+}
+
+// liveReferences returns a query for the live rows of the child referencing id.
+func (r reference) liveReferences(tx *gorm.DB, id uint) *gorm.DB {
+	query := tx.Session(&gorm.Session{NewDB: true}).Table(r.child.Table).Where(clause.Eq{Column: clause.Column{Name: r.foreignKey.DBName}, Value: id})
+	if isAuditableSchema(r.child) {
+		query = query.Where("deleted_at = 0")
+	}
+	return query
+}
+
+// checkReferences applies the ForeignKeyReject policy before the version identified by id is superseded.
+func (a MegaGormAuditPlugin) checkReferences(tx *gorm.DB, auditSchema *schema.Schema, id uint) error {
+	if a.ForeignKeys != ForeignKeyReject {
+		return nil
+	}
+
+	references, err := a.references(tx, auditSchema)
+	if err != nil {
+		return err
+	}
+
+	for _, r := range references {
+		var count int64
+		if err := r.liveReferences(tx, id).Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			return fmt.Errorf("%w: %d rows of %s.%s reference %s id %d",
+				ErrReferencedVersion, count, r.child.Table, r.foreignKey.DBName, auditSchema.Table, id)
+		}
+	}
+	return nil
+}
+
+// remapReferences applies the ForeignKeyRemap policy after the version identified by oldID is
+// superseded by newID. Auditable children are versioned, the others are updated in place.
+func (a MegaGormAuditPlugin) remapReferences(tx *gorm.DB, auditSchema *schema.Schema, oldID, newID uint) error {
+	if a.ForeignKeys != ForeignKeyRemap {
+		return nil
+	}
+
+	references, err := a.references(tx, auditSchema)
+	if err != nil {
+		return err
+	}
+
+	for _, r := range references {
+		if !isAuditableSchema(r.child) {
+			err := tx.Exec("UPDATE ? SET ? = ? WHERE ? = ?",
+				clause.Table{Name: r.child.Table}, clause.Column{Name: r.foreignKey.DBName}, newID, clause.Column{Name: r.foreignKey.DBName}, oldID).Error
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		children := reflect.New(reflect.SliceOf(r.child.ModelType))
+		if err := r.liveReferences(tx, oldID).Find(children.Interface()).Error; err != nil {
+			return err
+		}
+
+		for i := 0; i < children.Elem().Len(); i++ {
+			child := children.Elem().Index(i)
+			err := r.foreignKey.Set(tx.Statement.Context, child, newID)
+			if err == nil {
+				err = tx.Session(&gorm.Session{NewDB: true}).Updates(child.Addr().Interface()).Error
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,192 @@
+package MegaGormAudit
+
+import (
+	"errors"
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+	"testing"
+)
+
+func TestAuditPlugin_ForeignKeys(t *testing.T) {
+
+	type Employee struct {
+		ID        uint
+		CompanyID uint
+		Name      string
+	}
+	type Branch struct {
+		AuditableModel
+		CompanyID uint
+		Name      string
+	}
+	type Company struct {
+		AuditableModel
+		Name      string
+		Employees []Employee
+		Branches  []Branch
+	}
+	type Contract struct {
+		ID        uint
+		CompanyID uint
+		Company   Company
+		Code      string
+	}
+
+	unchanged := func(db *gorm.DB) bool {
+		var companies []Company
+		var employees []Employee
+		db.Unscoped().Find(&companies)
+		db.Find(&employees)
+		return len(companies) == 1 && companies[0].Name == "company" && len(employees) == 1 && employees[0].CompanyID == 1
+	}
+
+	tests := []struct {
+		name        string
+		plugin      MegaGormAuditPlugin
+		children    bool
+		pattern     string
+		wantErr     error
+		successTest func(db *gorm.DB) bool
+	}{
+		{
+			name:     "Success, keep references",
+			plugin:   MegaGormAuditPlugin{},
+			children: true,
+			successTest: func(db *gorm.DB) bool {
+				var employee Employee
+				var branch Branch
+				var contract Contract
+				db.First(&employee)
+				db.First(&branch)
+				db.First(&contract)
+				return employee.CompanyID == 1 && branch.ID == 1 && branch.CompanyID == 1 && contract.CompanyID == 1
+			},
+		},
+		{
+			name:     "Success, remap references",
+			plugin:   MegaGormAuditPlugin{ForeignKeys: ForeignKeyRemap, ReferencingModels: []interface{}{Contract{}}},
+			children: true,
+			successTest: func(db *gorm.DB) bool {
+				var employee Employee
+				var branches []Branch
+				var contract Contract
+				db.First(&employee)
+				db.Unscoped().Order("id").Find(&branches)
+				db.First(&contract)
+				return employee.CompanyID == 2 && contract.CompanyID == 2 && len(branches) == 2 &&
+					branches[0].CompanyID == 1 && branches[0].DeletedAt > 0 &&
+					branches[1].CompanyID == 2 && branches[1].DeletedAt == 0
+			},
+		},
+		{
+			name:     "Reject referenced version",
+			plugin:   MegaGormAuditPlugin{ForeignKeys: ForeignKeyReject, ReferencingModels: []interface{}{Contract{}}},
+			children: true,
+			wantErr:  ErrReferencedVersion,
+			successTest: func(db *gorm.DB) bool {
+				var companies []Company
+				db.Unscoped().Find(&companies)
+				return len(companies) == 1 && companies[0].Name == "company"
+			},
+		},
+		{
+			name:   "Success, reject without references",
+			plugin: MegaGormAuditPlugin{ForeignKeys: ForeignKeyReject, ReferencingModels: []interface{}{Contract{}}},
+			successTest: func(db *gorm.DB) bool {
+				var company Company
+				db.First(&company)
+				return company.ID == 2 && company.Name == "company updated"
+			},
+		},
+		{
+			name:    "Fail on invalid referencing model",
+			plugin:  MegaGormAuditPlugin{ForeignKeys: ForeignKeyReject, ReferencingModels: []interface{}{1}},
+			wantErr: schema.ErrUnsupportedDataType,
+			successTest: func(db *gorm.DB) bool {
+				return true
+			},
+		},
+		{
+			name:    "Fail on invalid referencing model while remapping",
+			plugin:  MegaGormAuditPlugin{ForeignKeys: ForeignKeyRemap, ReferencingModels: []interface{}{1}},
+			wantErr: schema.ErrUnsupportedDataType,
+			successTest: func(db *gorm.DB) bool {
+				return true
+			},
+		},
+		{
+			name:        "Fail counting references",
+			plugin:      MegaGormAuditPlugin{ForeignKeys: ForeignKeyReject},
+			children:    true,
+			pattern:     "^SELECT count\\(\\*\\) FROM `employees`",
+			wantErr:     errFailingStatement,
+			successTest: unchanged,
+		},
+		{
+			name:        "Fail remapping references",
+			plugin:      MegaGormAuditPlugin{ForeignKeys: ForeignKeyRemap},
+			children:    true,
+			pattern:     "^UPDATE `employees` SET",
+			wantErr:     errFailingStatement,
+			successTest: unchanged,
+		},
+		{
+			name:        "Fail loading referencing versions",
+			plugin:      MegaGormAuditPlugin{ForeignKeys: ForeignKeyRemap},
+			children:    true,
+			pattern:     "^SELECT \\* FROM `branches`",
+			wantErr:     errFailingStatement,
+			successTest: unchanged,
+		},
+		{
+			name:        "Fail versioning referencing versions",
+			plugin:      MegaGormAuditPlugin{ForeignKeys: ForeignKeyRemap},
+			children:    true,
+			pattern:     "^UPDATE `branches` SET",
+			wantErr:     errFailingStatement,
+			successTest: unchanged,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, err := createDatabaseWithPlugin(tt.plugin)
+			if err != nil {
+				t.Errorf("createDatabase() error = %v", err)
+				return
+			}
+
+			err = db.AutoMigrate(Company{}, Employee{}, Branch{}, Contract{})
+			if err != nil {
+				t.Errorf("AutoMigrate() error = %v", err)
+				return
+			}
+
+			company := &Company{Name: "company"}
+			err = db.Create(company).Error
+			if err != nil {
+				t.Errorf("Create() error = %v", err)
+				return
+			}
+
+			if tt.children {
+				db.Create(&Employee{CompanyID: company.ID, Name: "employee"})
+				db.Create(&Branch{CompanyID: company.ID, Name: "branch"})
+				db.Omit("Company").Create(&Contract{CompanyID: company.ID, Code: "contract"})
+			}
+
+			if tt.pattern != "" {
+				failOn(db, tt.pattern)
+			}
+			company.Name = "company updated"
+			err = db.Updates(company).Error
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("Updates() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+
+			if !tt.successTest(db) {
+				t.Errorf("successTest() = false, want true")
+			}
+		})
+	}
+}
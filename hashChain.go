@@ -0,0 +1,237 @@
+package MegaGormAudit
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql/driver"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+var ErrUnsupportedCreate = errors.New("megagormaudit: auditable models can't be created from maps")
+
+const (
+	ReasonHashMismatch         = "content hash mismatch"
+	ReasonPreviousHashMismatch = "previous hash mismatch"
+	ReasonSealMismatch         = "seal hash mismatch"
+	ReasonSupersededLive       = "superseded version is live"
+)
+
+// unhashedColumns are not part of the version hash, because they are assigned by the database
+// or stamped again when the version is superseded. The stamps of the version when it is created
+// are hashed through audit_stamp_hash, the ones added when it is superseded by its seal hash.
+// Fields updated in place, see versionedField, and redacted fields aren't part of it either.
+var unhashedColumns = map[string]bool{
+	"id":                true,
+	"audit_hash":        true,
+	"audit_seal_hash":   true,
+	"deleted_at":        true,
+	"last_changed_user": true,
+	"change_reason":     true,
+	"audit_metadata":    true,
+}
+
+// versionStamp holds the columns stamped on a version when it is superseded or deleted.
+type versionStamp struct {
+	DeletedAt       int64
+	LastChangedUser string
+	ChangeReason    string
+	AuditMetadata   Metadata
+}
+
+// set returns the assignments stamping a version with hash auditHash, sealed with its seal hash.
+func (s versionStamp) set(auditHash string) clause.Set {
+	return clause.Set{
+		{Column: clause.Column{Name: "deleted_at"}, Value: s.DeletedAt},
+		{Column: clause.Column{Name: "last_changed_user"}, Value: s.LastChangedUser},
+		{Column: clause.Column{Name: "change_reason"}, Value: s.ChangeReason},
+		{Column: clause.Column{Name: "audit_metadata"}, Value: s.AuditMetadata},
+		{Column: clause.Column{Name: "audit_seal_hash"}, Value: sealHash(auditHash, s)},
+	}
+}
+
+// stampHash returns the hash of the actor, reason and metadata of a version.
+func stampHash(actor, reason string, metadata Metadata) string {
+	hash := sha256.New()
+	fmt.Fprintf(hash, "last_changed_user=%s\nchange_reason=%s\naudit_metadata=%s\n", actor, reason, hashValue(metadata))
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// sealHash returns the hash linking the stamps of a superseded or deleted version to its hash.
+func sealHash(auditHash string, s versionStamp) string {
+	hash := sha256.New()
+	fmt.Fprintf(hash, "audit_hash=%s\ndeleted_at=%d\naudit_stamp_hash=%s\n", auditHash, s.DeletedAt, stampHash(s.LastChangedUser, s.ChangeReason, s.AuditMetadata))
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// sealVersion stamps the version id of table with stamp.
+func sealVersion(tx *gorm.DB, table string, id uint, auditHash string, stamp versionStamp) error {
+	set := stamp.set(auditHash)
+	assignments := make([]string, 0, len(set))
+	values := []interface{}{clause.Table{Name: table}}
+	for _, assignment := range set {
+		assignments = append(assignments, "? = ?")
+		values = append(values, assignment.Column, assignment.Value)
+	}
+	return AllowBypass(tx).Exec("UPDATE ? SET "+strings.Join(assignments, ", ")+" WHERE id = ?", append(values, id)...).Error
+}
+
+// TamperedVersion is a version reported by Verify.
+type TamperedVersion struct {
+	ID     uint
+	Reason string
+}
+
+// hashVersion stores the version hash of auditable models before they are created.
+// Versions created by deleteAndCreate already carry the hash of the superseded version,
+// original versions start a new chain.
+func hashVersion(db *gorm.DB) {
+	if db.Error != nil || db.Statement.Schema == nil || !isAuditableSchema(db.Statement.Schema) {
+		return
+	}
+	registerAuditableTable(db, db.Statement.Table)
+
+	//without the model, the version couldn't be hashed as it's stored
+	if mapRows(db.Statement.Dest) != nil {
+		db.AddError(ErrUnsupportedCreate)
+		return
+	}
+
+	switch db.Statement.ReflectValue.Kind() {
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < db.Statement.ReflectValue.Len(); i++ {
+			db.AddError(hashReflectValue(db, reflect.Indirect(db.Statement.ReflectValue.Index(i))))
+		}
+	case reflect.Struct:
+		db.AddError(hashReflectValue(db, db.Statement.ReflectValue))
+	}
+}
+
+func hashReflectValue(db *gorm.DB, rv reflect.Value) error {
+	ctx := db.Statement.Context
+	fields := db.Statement.Schema.FieldsByDBName
+	curTime := db.Statement.DB.NowFunc()
+
+	for _, field := range fields {
+		if field.AutoCreateTime > 0 || field.AutoUpdateTime > 0 {
+			if _, isZero := field.ValueOf(ctx, rv); isZero {
+				if err := field.Set(ctx, rv, curTime); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	auditableModel := rv.FieldByName("AuditableModel").Addr().Interface().(*AuditableModel)
+	if auditableModel.AuditParentID == nil {
+		auditableModel.AuditPrevHash = ""
+	}
+	auditableModel.AuditStampHash = stampHash(auditableModel.LastChangedUser, auditableModel.ChangeReason, auditableModel.AuditMetadata)
+	auditableModel.AuditSealHash = ""
+	auditableModel.AuditHash = contentHash(ctx, db.Statement.Schema, rv)
+	return nil
+}
+
+// contentHash returns the hash of the version content, including the hash of the previous version.
+// Zero and NULL columns are left out, so versions stored before a column is added keep their hash.
+func contentHash(ctx context.Context, auditSchema *schema.Schema, rv reflect.Value) string {
+	hash := sha256.New()
+	for _, name := range auditSchema.DBNames {
+		field := auditSchema.FieldsByDBName[name]
+		if unhashedColumns[name] || !versionedField(auditSchema, field) || redaction(field) != "" {
+			continue
+		}
+		value, zero := field.ValueOf(ctx, rv)
+		if zero {
+			continue
+		}
+		fmt.Fprintf(hash, "%s=%s\n", name, hashValue(value))
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+func hashValue(value interface{}) string {
+	if valuer, ok := value.(driver.Valuer); ok {
+		value, _ = valuer.Value()
+	}
+
+	rv := reflect.Indirect(reflect.ValueOf(value))
+	if !rv.IsValid() {
+		return "NULL"
+	}
+
+	if t, ok := rv.Interface().(time.Time); ok {
+		return t.UTC().Truncate(time.Millisecond).Format(time.RFC3339Nano)
+	}
+	return fmt.Sprint(rv.Interface())
+}
+
+// Verify walks the version chains of model, including archived versions, and returns the versions
+// whose stored hash doesn't match their content or the hash of the previous version, superseded
+// versions whose stamps don't match their seal hash and superseded versions made live again.
+// Versions removed by Purge or Archive are skipped through the anchors they record, removing
+// versions any other way breaks the chain and is reported on the version that follows them.
+func Verify(ctx context.Context, db *gorm.DB, model interface{}) ([]TamperedVersion, error) {
+	db = db.WithContext(ctx)
+	auditSchema, err := auditableSchema(db, model)
+	if err != nil {
+		return nil, err
+	}
+
+	versions := reflect.New(reflect.SliceOf(auditSchema.ModelType))
+	if err := findVersions(db, auditSchema.Table, versions.Interface()); err != nil {
+		return nil, err
+	}
+
+	anchors, err := chainAnchors(db, auditSchema.Table)
+	if err != nil {
+		return nil, err
+	}
+
+	var tampered []TamperedVersion
+	lastHashes := map[uint]string{}
+	liveVersions := map[uint]uint{}
+	for i := 0; i < versions.Elem().Len(); i++ {
+		rv := versions.Elem().Index(i)
+		version := auditableModelOf(rv.Interface())
+
+		rootID := version.ID
+		if version.AuditParentID != nil {
+			rootID = *version.AuditParentID
+		}
+
+		if liveID, ok := liveVersions[rootID]; ok {
+			tampered = append(tampered, TamperedVersion{ID: liveID, Reason: ReasonSupersededLive})
+			delete(liveVersions, rootID)
+		}
+
+		stamp := versionStamp{DeletedAt: int64(version.DeletedAt), LastChangedUser: version.LastChangedUser, ChangeReason: version.ChangeReason, AuditMetadata: version.AuditMetadata}
+		switch {
+		case contentHash(ctx, auditSchema, rv) != version.AuditHash:
+			tampered = append(tampered, TamperedVersion{ID: version.ID, Reason: ReasonHashMismatch})
+		case version.AuditPrevHash != lastHashes[rootID] && !anchored(anchors[version.ID], lastHashes[rootID], version.AuditPrevHash):
+			tampered = append(tampered, TamperedVersion{ID: version.ID, Reason: ReasonPreviousHashMismatch})
+		case version.DeletedAt == 0 && (version.AuditSealHash != "" || stampHash(stamp.LastChangedUser, stamp.ChangeReason, stamp.AuditMetadata) != version.AuditStampHash):
+			tampered = append(tampered, TamperedVersion{ID: version.ID, Reason: ReasonHashMismatch})
+		case version.DeletedAt != 0 && sealHash(version.AuditHash, stamp) != version.AuditSealHash:
+			tampered = append(tampered, TamperedVersion{ID: version.ID, Reason: ReasonSealMismatch})
+		}
+		if version.DeletedAt == 0 {
+			liveVersions[rootID] = version.ID
+		}
+		lastHashes[rootID] = version.AuditHash
+	}
+
+	sort.SliceStable(tampered, func(i, j int) bool {
+		return tampered[i].ID < tampered[j].ID
+	})
+	return tampered, nil
+}
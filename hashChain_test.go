@@ -0,0 +1,265 @@
+package MegaGormAudit
+
+import (
+	"context"
+	"errors"
+	"gorm.io/gorm"
+	"reflect"
+	"testing"
+)
+
+func TestVerify(t *testing.T) {
+
+	type Player struct {
+		AuditableModel
+		Name     string
+		NickName *string
+	}
+
+	tests := []struct {
+		name   string
+		tamper func(db *gorm.DB) error
+		want   []TamperedVersion
+	}{
+		{
+			name: "Success, chain without changes",
+			want: nil,
+		},
+		{
+			name: "Changed content",
+			tamper: func(db *gorm.DB) error {
+				return db.Exec("UPDATE players SET name = ? WHERE id = ?", "tampered", 2).Error
+			},
+			want: []TamperedVersion{{ID: 2, Reason: ReasonHashMismatch}},
+		},
+		{
+			name: "Changed content and hash",
+			tamper: func(db *gorm.DB) error {
+				return db.Exec("UPDATE players SET audit_hash = ? WHERE id = ?", "tampered", 2).Error
+			},
+			want: []TamperedVersion{{ID: 2, Reason: ReasonHashMismatch}, {ID: 3, Reason: ReasonPreviousHashMismatch}},
+		},
+		{
+			name: "Removed version",
+			tamper: func(db *gorm.DB) error {
+				return db.Exec("DELETE FROM players WHERE id = ?", 2).Error
+			},
+			want: []TamperedVersion{{ID: 3, Reason: ReasonPreviousHashMismatch}},
+		},
+		{
+			name: "Success, deleted version sealed with its stamps",
+			tamper: func(db *gorm.DB) error {
+				return db.Delete(&Player{AuditableModel: AuditableModel{ID: 3, LastChangedUser: "Deleting User"}}).Error
+			},
+			want: nil,
+		},
+		{
+			name: "Changed actor of live version",
+			tamper: func(db *gorm.DB) error {
+				return db.Exec("UPDATE players SET last_changed_user = ? WHERE id = ?", "mallory", 3).Error
+			},
+			want: []TamperedVersion{{ID: 3, Reason: ReasonHashMismatch}},
+		},
+		{
+			name: "Changed reason of superseded version",
+			tamper: func(db *gorm.DB) error {
+				return db.Exec("UPDATE players SET change_reason = ? WHERE id = ?", "tampered", 1).Error
+			},
+			want: []TamperedVersion{{ID: 1, Reason: ReasonSealMismatch}},
+		},
+		{
+			name: "Changed metadata of superseded version",
+			tamper: func(db *gorm.DB) error {
+				return db.Exec("UPDATE players SET audit_metadata = ? WHERE id = ?", `{"client_ip":"10.0.0.1"}`, 2).Error
+			},
+			want: []TamperedVersion{{ID: 2, Reason: ReasonSealMismatch}},
+		},
+		{
+			name: "Revived superseded version",
+			tamper: func(db *gorm.DB) error {
+				return db.Exec("UPDATE players SET deleted_at = 0, audit_seal_hash = '' WHERE id = ?", 2).Error
+			},
+			want: []TamperedVersion{{ID: 2, Reason: ReasonSupersededLive}},
+		},
+		{
+			name: "Changed deletion time of superseded version",
+			tamper: func(db *gorm.DB) error {
+				return db.Exec("UPDATE players SET deleted_at = deleted_at + 1 WHERE id = ?", 1).Error
+			},
+			want: []TamperedVersion{{ID: 1, Reason: ReasonSealMismatch}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, err := createDatabase()
+			if err != nil {
+				t.Errorf("createDatabase() error = %v", err)
+				return
+			}
+
+			err = db.AutoMigrate(Player{})
+			if err != nil {
+				t.Errorf("AutoMigrate() error = %v", err)
+				return
+			}
+
+			nickName := "nick"
+			player := &Player{Name: "teste", NickName: &nickName}
+			err = db.Create(player).Error
+			if err != nil {
+				t.Errorf("Create() error = %v", err)
+				return
+			}
+
+			for _, name := range []string{"teste atualizado", "teste atualizado 2"} {
+				player.Name = name
+				player.NickName = nil
+				err = db.Updates(player).Error
+				if err != nil {
+					t.Errorf("Updates() error = %v", err)
+					return
+				}
+			}
+
+			if tt.tamper != nil {
+				err = tt.tamper(db)
+				if err != nil {
+					t.Errorf("tamper() error = %v", err)
+					return
+				}
+			}
+
+			tampered, err := Verify(context.Background(), db, &Player{})
+			if err != nil {
+				t.Errorf("Verify() error = %v", err)
+				return
+			}
+
+			if !reflect.DeepEqual(tampered, tt.want) {
+				t.Errorf("Verify() = %v, want %v", tampered, tt.want)
+			}
+		})
+	}
+}
+
+type RosterPlayer struct {
+	AuditableModel
+	Name string
+}
+
+func (RosterPlayer) TableName() string {
+	return "roster_players"
+}
+
+// RosterPlayerWithNickname is RosterPlayer after a column is added.
+type RosterPlayerWithNickname struct {
+	AuditableModel
+	Name     string
+	Nickname string
+}
+
+func (RosterPlayerWithNickname) TableName() string {
+	return "roster_players"
+}
+
+func TestVerify_AddedColumn(t *testing.T) {
+
+	db, err := createDatabase()
+	if err != nil {
+		t.Errorf("createDatabase() error = %v", err)
+		return
+	}
+
+	err = db.AutoMigrate(RosterPlayer{})
+	if err != nil {
+		t.Errorf("AutoMigrate() error = %v", err)
+		return
+	}
+
+	player := &RosterPlayer{Name: "teste"}
+	db.Create(player)
+	player.Name = "teste atualizado"
+	db.Updates(player)
+
+	err = db.AutoMigrate(RosterPlayerWithNickname{})
+	if err != nil {
+		t.Errorf("AutoMigrate() error = %v", err)
+		return
+	}
+
+	tampered, err := Verify(context.Background(), db, &RosterPlayerWithNickname{})
+	if err != nil || tampered != nil {
+		t.Errorf("Verify() = %v, %v, want no tampered versions", tampered, err)
+	}
+
+	withNickname := &RosterPlayerWithNickname{}
+	db.Take(withNickname)
+	withNickname.Nickname = "nick"
+	err = db.Updates(withNickname).Error
+	if err != nil {
+		t.Errorf("Updates() error = %v", err)
+		return
+	}
+
+	tampered, err = Verify(context.Background(), db, &RosterPlayerWithNickname{})
+	if err != nil || tampered != nil {
+		t.Errorf("Verify() = %v, %v, want no tampered versions", tampered, err)
+	}
+}
+
+func TestVerify_Fail(t *testing.T) {
+
+	type normalModel struct {
+		ID   uint64 `gorm:"primaryKey"`
+		Name string
+	}
+
+	db, err := createDatabase()
+	if err != nil {
+		t.Errorf("createDatabase() error = %v", err)
+		return
+	}
+
+	_, err = Verify(context.Background(), db, &normalModel{})
+	if !errors.Is(err, ErrNotAuditable) {
+		t.Errorf("Verify() error = %v, want %v", err, ErrNotAuditable)
+	}
+
+	_, err = Verify(context.Background(), db, &PlayerErrorOnCreate{})
+	if err == nil {
+		t.Errorf("Verify() error = nil, want error")
+	}
+}
+
+func TestHashVersion_Fail(t *testing.T) {
+
+	type Player struct {
+		AuditableModel
+		Name     string
+		JoinedAt string `gorm:"autoCreateTime"`
+	}
+
+	db, err := createDatabase()
+	if err != nil {
+		t.Errorf("createDatabase() error = %v", err)
+		return
+	}
+
+	err = db.AutoMigrate(Player{})
+	if err != nil {
+		t.Errorf("AutoMigrate() error = %v", err)
+		return
+	}
+
+	err = db.Create(&Player{Name: "teste"}).Error
+	if err == nil {
+		t.Errorf("Create() error = nil, want error")
+	}
+
+	for _, values := range []interface{}{map[string]interface{}{"name": "teste"}, []map[string]interface{}{{"name": "teste"}}} {
+		err = db.Model(&Player{}).Create(values).Error
+		if !errors.Is(err, ErrUnsupportedCreate) {
+			t.Errorf("Create() error = %v, want %v", err, ErrUnsupportedCreate)
+		}
+	}
+}
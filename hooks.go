@@ -0,0 +1,84 @@
+package MegaGormAudit
+
+import (
+	"gorm.io/gorm"
+)
+
+const (
+	supersedingKey    = "megagormaudit:superseding"
+	deletedVersionKey = "megagormaudit:deleted_version"
+)
+
+// BeforeAuditVersionInterface is implemented by auditable models or hooks registered in the plugin
+// that are called inside the audit transaction before a version is superseded.
+// On updates old is the stored version and new is the version being created, which can still be changed.
+// On deletes new is nil. Returning an error aborts the change.
+type BeforeAuditVersionInterface interface {
+	BeforeAuditVersion(tx *gorm.DB, old, new interface{}) error
+}
+
+// AfterAuditVersionInterface is implemented by auditable models or hooks registered in the plugin
+// that are called inside the audit transaction after a version is superseded.
+// On updates new is the created version, on deletes new is nil.
+type AfterAuditVersionInterface interface {
+	AfterAuditVersion(tx *gorm.DB, old, new interface{})
+}
+
+// pluginOf returns the MegaGormAuditPlugin registered in db.
+func pluginOf(db *gorm.DB) MegaGormAuditPlugin {
+	plugin, _ := db.Config.Plugins[MegaGormAuditPlugin{}.Name()].(MegaGormAuditPlugin)
+	return plugin
+}
+
+func (a MegaGormAuditPlugin) hasVersionHooks(model interface{}) bool {
+	_, before := model.(BeforeAuditVersionInterface)
+	_, after := model.(AfterAuditVersionInterface)
+	return len(a.Hooks) > 0 || before || after
+}
+
+func (a MegaGormAuditPlugin) beforeVersion(tx *gorm.DB, model, old, new interface{}) error {
+	for _, hook := range a.Hooks {
+		if before, ok := hook.(BeforeAuditVersionInterface); ok {
+			if err := before.BeforeAuditVersion(tx, old, new); err != nil {
+				return err
+			}
+		}
+	}
+
+	if before, ok := model.(BeforeAuditVersionInterface); ok {
+		return before.BeforeAuditVersion(tx, old, new)
+	}
+	return nil
+}
+
+func (a MegaGormAuditPlugin) afterVersion(tx *gorm.DB, model, old, new interface{}) {
+	for _, hook := range a.Hooks {
+		if after, ok := hook.(AfterAuditVersionInterface); ok {
+			after.AfterAuditVersion(tx, old, new)
+		}
+	}
+
+	if after, ok := model.(AfterAuditVersionInterface); ok {
+		after.AfterAuditVersion(tx, old, new)
+	}
+}
+
+// afterDelete calls the AfterAuditVersion hooks and emits the event of the version deleted by AuditableModel.BeforeDelete.
+func (a MegaGormAuditPlugin) afterDelete(db *gorm.DB) {
+	old, ok := db.Statement.Settings.Load(deletedVersionKey)
+	if !ok || db.Error != nil {
+		return
+	}
+
+	tx := db.Session(&gorm.Session{NewDB: true})
+	if a.hasVersionHooks(db.Statement.Model) {
+		a.afterVersion(tx, db.Statement.Model, old, nil)
+	}
+
+	if db.RowsAffected > 0 && a.emitsEvents() {
+		event := auditEvent(tx, db.Statement.Schema, EventDelete, old, nil)
+		deleted := auditableModelOf(db.Statement.Model)
+		event.Actor, event.Reason, event.Metadata = deleted.LastChangedUser, deleted.ChangeReason, deleted.AuditMetadata
+		db.AddError(a.emit(tx, event))
+	}
+}
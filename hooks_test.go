@@ -0,0 +1,214 @@
+package MegaGormAudit
+
+import (
+	"errors"
+	"gorm.io/gorm"
+	"testing"
+)
+
+var errVetoedVersion = errors.New("vetoed version")
+
+type PlayerWithVersionHooks struct {
+	AuditableModel
+	Name     string
+	NickName string
+}
+
+func (p *PlayerWithVersionHooks) BeforeAuditVersion(tx *gorm.DB, old, new interface{}) error {
+	if p.Name == "veto" {
+		return errVetoedVersion
+	}
+	if new != nil {
+		new.(*PlayerWithVersionHooks).NickName = old.(*PlayerWithVersionHooks).Name
+	}
+	return nil
+}
+
+func (p *PlayerWithVersionHooks) AfterAuditVersion(tx *gorm.DB, old, new interface{}) {
+	p.NickName = "after"
+}
+
+type versionHookCall struct {
+	oldID uint
+	newID uint
+}
+
+type recordingVersionHook struct {
+	before []versionHookCall
+	after  []versionHookCall
+	err    error //returned by BeforeAuditVersion
+}
+
+func (h *recordingVersionHook) BeforeAuditVersion(tx *gorm.DB, old, new interface{}) error {
+	h.before = append(h.before, h.call(old, new))
+	return h.err
+}
+
+func (h *recordingVersionHook) AfterAuditVersion(tx *gorm.DB, old, new interface{}) {
+	h.after = append(h.after, h.call(old, new))
+}
+
+func (h *recordingVersionHook) call(old, new interface{}) versionHookCall {
+	call := versionHookCall{oldID: auditableModelOf(old).ID}
+	if new != nil {
+		call.newID = auditableModelOf(new).ID
+	}
+	return call
+}
+
+func TestAuditPlugin_VersionHooks(t *testing.T) {
+
+	tests := []struct {
+		name        string
+		hookErr     error
+		change      func(db *gorm.DB, model *PlayerWithVersionHooks) error
+		wantErr     error
+		wantBefore  []versionHookCall
+		wantAfter   []versionHookCall
+		successTest func(db *gorm.DB, model *PlayerWithVersionHooks) bool
+	}{
+		{
+			name: "Success on update",
+			change: func(db *gorm.DB, model *PlayerWithVersionHooks) error {
+				model.Name = "teste atualizado"
+				return db.Updates(model).Error
+			},
+			wantBefore: []versionHookCall{{oldID: 1, newID: 1}},
+			wantAfter:  []versionHookCall{{oldID: 1, newID: 2}},
+			successTest: func(db *gorm.DB, model *PlayerWithVersionHooks) bool {
+				var row PlayerWithVersionHooks
+				db.First(&row)
+				return row.ID == 2 && row.NickName == "teste" && model.NickName == "after"
+			},
+		},
+		{
+			name: "Vetoed update",
+			change: func(db *gorm.DB, model *PlayerWithVersionHooks) error {
+				model.Name = "veto"
+				return db.Updates(model).Error
+			},
+			wantErr:    errVetoedVersion,
+			wantBefore: []versionHookCall{{oldID: 1, newID: 1}},
+			successTest: func(db *gorm.DB, model *PlayerWithVersionHooks) bool {
+				var rows []PlayerWithVersionHooks
+				db.Unscoped().Find(&rows)
+				return len(rows) == 1 && rows[0].DeletedAt == 0 && rows[0].Name == "teste"
+			},
+		},
+		{
+			name:    "Update vetoed by plugin hook",
+			hookErr: errVetoedVersion,
+			change: func(db *gorm.DB, model *PlayerWithVersionHooks) error {
+				model.Name = "teste atualizado"
+				return db.Updates(model).Error
+			},
+			wantErr:    errVetoedVersion,
+			wantBefore: []versionHookCall{{oldID: 1, newID: 1}},
+			successTest: func(db *gorm.DB, model *PlayerWithVersionHooks) bool {
+				var rows []PlayerWithVersionHooks
+				db.Unscoped().Find(&rows)
+				return len(rows) == 1 && rows[0].DeletedAt == 0 && rows[0].Name == "teste"
+			},
+		},
+		{
+			name: "Success on delete",
+			change: func(db *gorm.DB, model *PlayerWithVersionHooks) error {
+				return db.Delete(model).Error
+			},
+			wantBefore: []versionHookCall{{oldID: 1}},
+			wantAfter:  []versionHookCall{{oldID: 1}},
+			successTest: func(db *gorm.DB, model *PlayerWithVersionHooks) bool {
+				var rows []PlayerWithVersionHooks
+				db.Find(&rows)
+				return len(rows) == 0 && model.NickName == "after"
+			},
+		},
+		{
+			name: "Vetoed delete",
+			change: func(db *gorm.DB, model *PlayerWithVersionHooks) error {
+				model.Name = "veto"
+				return db.Delete(model).Error
+			},
+			wantErr:    errVetoedVersion,
+			wantBefore: []versionHookCall{{oldID: 1}},
+			successTest: func(db *gorm.DB, model *PlayerWithVersionHooks) bool {
+				var rows []PlayerWithVersionHooks
+				db.Find(&rows)
+				return len(rows) == 1
+			},
+		},
+		{
+			name: "Fail loading the deleted version",
+			change: func(db *gorm.DB, model *PlayerWithVersionHooks) error {
+				failOn(db, "^SELECT")
+				return db.Delete(model).Error
+			},
+			wantErr: errFailingStatement,
+			successTest: func(db *gorm.DB, model *PlayerWithVersionHooks) bool {
+				return model.NickName == ""
+			},
+		},
+		{
+			name: "Success, delete of missing version without hooks",
+			change: func(db *gorm.DB, model *PlayerWithVersionHooks) error {
+				return db.Delete(&PlayerWithVersionHooks{AuditableModel: AuditableModel{ID: 99}}).Error
+			},
+			successTest: func(db *gorm.DB, model *PlayerWithVersionHooks) bool {
+				var rows []PlayerWithVersionHooks
+				db.Find(&rows)
+				return len(rows) == 1
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hook := &recordingVersionHook{err: tt.hookErr}
+			db, err := createDatabaseWithPlugin(MegaGormAuditPlugin{Hooks: []interface{}{hook}})
+			if err != nil {
+				t.Errorf("createDatabase() error = %v", err)
+				return
+			}
+
+			err = db.AutoMigrate(PlayerWithVersionHooks{})
+			if err != nil {
+				t.Errorf("AutoMigrate() error = %v", err)
+				return
+			}
+
+			model := &PlayerWithVersionHooks{Name: "teste"}
+			err = db.Create(model).Error
+			if err != nil {
+				t.Errorf("Create() error = %v", err)
+				return
+			}
+
+			err = tt.change(db, model)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("change() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+
+			if !equalVersionHookCalls(hook.before, tt.wantBefore) {
+				t.Errorf("BeforeAuditVersion() calls = %v, want %v", hook.before, tt.wantBefore)
+			}
+			if !equalVersionHookCalls(hook.after, tt.wantAfter) {
+				t.Errorf("AfterAuditVersion() calls = %v, want %v", hook.after, tt.wantAfter)
+			}
+			if !tt.successTest(db, model) {
+				t.Errorf("successTest() = false, want true")
+			}
+		})
+	}
+}
+
+func equalVersionHookCalls(calls, want []versionHookCall) bool {
+	if len(calls) != len(want) {
+		return false
+	}
+	for i := range calls {
+		if calls[i] != want[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,152 @@
+package MegaGormAudit
+
+import (
+	"context"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"sort"
+)
+
+const (
+	IssueMultipleLiveVersions = "multiple live versions"
+	IssueNonRootParent        = "audit parent is not an original version"
+	IssueOrphanVersion        = "audit parent does not exist"
+)
+
+// Inconsistency is a version reported by the integrity checker.
+type Inconsistency struct {
+	ID       uint
+	Issue    string
+	Repaired bool
+}
+
+// Check scans the table of model and reports its inconsistent versions.
+func Check(ctx context.Context, db *gorm.DB, model interface{}) ([]Inconsistency, error) {
+	auditSchema, err := auditableSchema(db, model)
+	if err != nil {
+		return nil, err
+	}
+	return CheckTable(ctx, db, auditSchema.Table)
+}
+
+// Repair scans the table of model, reports its inconsistent versions and fixes the ones
+// that can be fixed deterministically.
+func Repair(ctx context.Context, db *gorm.DB, model interface{}) ([]Inconsistency, error) {
+	auditSchema, err := auditableSchema(db, model)
+	if err != nil {
+		return nil, err
+	}
+	return RepairTable(ctx, db, auditSchema.Table)
+}
+
+// CheckTable scans an auditable table and reports its inconsistent versions:
+// records with more than one live version, versions whose audit parent is not an
+// original version and versions whose audit parent does not exist.
+func CheckTable(ctx context.Context, db *gorm.DB, table string) ([]Inconsistency, error) {
+	return checkTable(db.WithContext(ctx), table, false)
+}
+
+// RepairTable scans an auditable table like CheckTable and fixes the inconsistencies that
+// can be fixed deterministically: versions pointing at a non original version are pointed at
+// the original one and only the most recent live version of a record is kept live.
+// Orphan versions are only reported.
+func RepairTable(ctx context.Context, db *gorm.DB, table string) ([]Inconsistency, error) {
+	return checkTable(db.WithContext(ctx), table, true)
+}
+
+func checkTable(db *gorm.DB, table string, repair bool) ([]Inconsistency, error) {
+	var rows []versionRow
+	err := db.Table(table).Select("id", "audit_parent_id", "deleted_at").Order("id").Find(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	byID := make(map[uint]versionRow, len(rows))
+	for _, row := range rows {
+		byID[row.ID] = row
+	}
+
+	var (
+		inconsistencies []Inconsistency
+		reparent        = map[uint]uint{}
+		liveVersions    = map[uint][]uint{}
+	)
+	for _, row := range rows {
+		rootID, ok := rootOf(byID, row)
+		switch {
+		case !ok:
+			inconsistencies = append(inconsistencies, Inconsistency{ID: row.ID, Issue: IssueOrphanVersion})
+			continue
+		case row.AuditParentID != nil && *row.AuditParentID != rootID:
+			inconsistencies = append(inconsistencies, Inconsistency{ID: row.ID, Issue: IssueNonRootParent, Repaired: repair})
+			reparent[row.ID] = rootID
+		}
+
+		if row.DeletedAt == 0 {
+			liveVersions[rootID] = append(liveVersions[rootID], row.ID)
+		}
+	}
+
+	var superseded []uint
+	for _, ids := range liveVersions {
+		for _, id := range ids[:len(ids)-1] {
+			inconsistencies = append(inconsistencies, Inconsistency{ID: id, Issue: IssueMultipleLiveVersions, Repaired: repair})
+			superseded = append(superseded, id)
+		}
+	}
+
+	sort.SliceStable(inconsistencies, func(i, j int) bool {
+		return inconsistencies[i].ID < inconsistencies[j].ID
+	})
+
+	if !repair || len(inconsistencies) == 0 {
+		return inconsistencies, nil
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		for id, rootID := range reparent {
+			err := AllowBypass(tx).Exec("UPDATE ? SET audit_parent_id = ? WHERE id = ?", clause.Table{Name: table}, rootID, id).Error
+			if err != nil {
+				return err
+			}
+		}
+
+		var versions []struct {
+			ID              uint
+			AuditHash       string
+			LastChangedUser string
+			ChangeReason    string
+			AuditMetadata   Metadata
+		}
+		if len(superseded) > 0 {
+			if err := tx.Table(table).Where("id IN ?", superseded).Find(&versions).Error; err != nil {
+				return err
+			}
+		}
+
+		deletedAt := tx.NowFunc().UnixMilli()
+		for _, version := range versions {
+			stamp := versionStamp{DeletedAt: deletedAt, LastChangedUser: version.LastChangedUser, ChangeReason: version.ChangeReason, AuditMetadata: version.AuditMetadata}
+			if err := sealVersion(tx, table, version.ID, version.AuditHash, stamp); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return inconsistencies, err
+}
+
+// rootOf follows the audit parents of row up to the original version.
+// It returns false when the chain reaches a missing version or loops.
+func rootOf(byID map[uint]versionRow, row versionRow) (uint, bool) {
+	visited := map[uint]bool{row.ID: true}
+	for row.AuditParentID != nil {
+		parent, ok := byID[*row.AuditParentID]
+		if !ok || visited[parent.ID] {
+			return 0, false
+		}
+		visited[parent.ID] = true
+		row = parent
+	}
+	return row.ID, true
+}
@@ -0,0 +1,241 @@
+package MegaGormAudit
+
+import (
+	"context"
+	"errors"
+	"gorm.io/gorm"
+	"reflect"
+	"testing"
+)
+
+func TestCheck(t *testing.T) {
+
+	type Player struct {
+		AuditableModel
+		Name string
+	}
+
+	tests := []struct {
+		name        string
+		corrupt     func(db *gorm.DB) error
+		repair      bool
+		want        []Inconsistency
+		successTest func(db *gorm.DB) bool
+	}{
+		{
+			name: "Success, consistent table",
+			want: nil,
+		},
+		{
+			name: "Multiple live versions",
+			corrupt: func(db *gorm.DB) error {
+				return db.Exec("UPDATE players SET deleted_at = 0 WHERE id IN (1, 2)").Error
+			},
+			want: []Inconsistency{{ID: 1, Issue: IssueMultipleLiveVersions}, {ID: 2, Issue: IssueMultipleLiveVersions}},
+		},
+		{
+			name: "Repair multiple live versions",
+			corrupt: func(db *gorm.DB) error {
+				return db.Exec("UPDATE players SET deleted_at = 0 WHERE id IN (1, 2)").Error
+			},
+			repair: true,
+			want:   []Inconsistency{{ID: 1, Issue: IssueMultipleLiveVersions, Repaired: true}, {ID: 2, Issue: IssueMultipleLiveVersions, Repaired: true}},
+			successTest: func(db *gorm.DB) bool {
+				var rows []Player
+				db.Find(&rows)
+				tampered, err := Verify(context.Background(), db, &Player{})
+				return len(rows) == 1 && rows[0].ID == 3 && err == nil && len(tampered) == 0
+			},
+		},
+		{
+			name: "Parent is not an original version",
+			corrupt: func(db *gorm.DB) error {
+				return db.Exec("UPDATE players SET audit_parent_id = 2 WHERE id = 3").Error
+			},
+			want: []Inconsistency{{ID: 3, Issue: IssueNonRootParent}},
+		},
+		{
+			name: "Repair parent is not an original version",
+			corrupt: func(db *gorm.DB) error {
+				return db.Exec("UPDATE players SET audit_parent_id = 2 WHERE id = 3").Error
+			},
+			repair: true,
+			want:   []Inconsistency{{ID: 3, Issue: IssueNonRootParent, Repaired: true}},
+			successTest: func(db *gorm.DB) bool {
+				var row Player
+				db.First(&row, 3)
+				return row.AuditParentID != nil && *row.AuditParentID == 1
+			},
+		},
+		{
+			name: "Orphan versions are not repaired",
+			corrupt: func(db *gorm.DB) error {
+				return db.Exec("DELETE FROM players WHERE id = 1").Error
+			},
+			repair: true,
+			want:   []Inconsistency{{ID: 2, Issue: IssueOrphanVersion}, {ID: 3, Issue: IssueOrphanVersion}},
+			successTest: func(db *gorm.DB) bool {
+				var rows []Player
+				db.Unscoped().Find(&rows)
+				return len(rows) == 2
+			},
+		},
+		{
+			name: "Looping parents",
+			corrupt: func(db *gorm.DB) error {
+				return db.Exec("UPDATE players SET audit_parent_id = 2 WHERE id = 1").Error
+			},
+			want: []Inconsistency{{ID: 1, Issue: IssueOrphanVersion}, {ID: 2, Issue: IssueOrphanVersion}, {ID: 3, Issue: IssueOrphanVersion}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, err := createDatabase()
+			if err != nil {
+				t.Errorf("createDatabase() error = %v", err)
+				return
+			}
+
+			err = db.AutoMigrate(Player{})
+			if err != nil {
+				t.Errorf("AutoMigrate() error = %v", err)
+				return
+			}
+
+			player := &Player{Name: "teste"}
+			db.Create(player)
+			for _, name := range []string{"teste atualizado", "teste atualizado 2"} {
+				player.Name = name
+				err = db.Updates(player).Error
+				if err != nil {
+					t.Errorf("Updates() error = %v", err)
+					return
+				}
+			}
+
+			if tt.corrupt != nil {
+				err = tt.corrupt(db)
+				if err != nil {
+					t.Errorf("corrupt() error = %v", err)
+					return
+				}
+			}
+
+			check := Check
+			if tt.repair {
+				check = Repair
+			}
+
+			inconsistencies, err := check(context.Background(), db, &Player{})
+			if err != nil {
+				t.Errorf("Check() error = %v", err)
+				return
+			}
+
+			if !reflect.DeepEqual(inconsistencies, tt.want) {
+				t.Errorf("Check() = %v, want %v", inconsistencies, tt.want)
+			}
+
+			if tt.successTest != nil && !tt.successTest(db) {
+				t.Errorf("successTest() = false, want true")
+			}
+		})
+	}
+}
+
+func TestCheck_Fail(t *testing.T) {
+
+	type normalModel struct {
+		ID   uint64 `gorm:"primaryKey"`
+		Name string
+	}
+
+	db, err := createDatabase()
+	if err != nil {
+		t.Errorf("createDatabase() error = %v", err)
+		return
+	}
+
+	_, err = Check(context.Background(), db, &normalModel{})
+	if !errors.Is(err, ErrNotAuditable) {
+		t.Errorf("Check() error = %v, want %v", err, ErrNotAuditable)
+	}
+
+	_, err = Repair(context.Background(), db, &normalModel{})
+	if !errors.Is(err, ErrNotAuditable) {
+		t.Errorf("Repair() error = %v, want %v", err, ErrNotAuditable)
+	}
+
+	_, err = CheckTable(context.Background(), db, "missing_table")
+	if err == nil {
+		t.Errorf("CheckTable() error = nil, want error")
+	}
+}
+
+func TestRepair_FailOnDatabase(t *testing.T) {
+
+	type Player struct {
+		AuditableModel
+		Name string
+	}
+
+	tests := []struct {
+		name    string
+		corrupt string
+		pattern string
+	}{
+		{
+			name:    "Fail setting the original version as parent",
+			corrupt: "UPDATE players SET audit_parent_id = 2 WHERE id = 3",
+			pattern: "^UPDATE `players` SET audit_parent_id",
+		},
+		{
+			name:    "Fail loading superseded versions",
+			corrupt: "UPDATE players SET deleted_at = 0 WHERE id IN (1, 2)",
+			pattern: "^SELECT \\* FROM `players` WHERE id IN",
+		},
+		{
+			name:    "Fail sealing superseded versions",
+			corrupt: "UPDATE players SET deleted_at = 0 WHERE id IN (1, 2)",
+			pattern: "^UPDATE `players` SET `deleted_at`",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, err := createDatabase()
+			if err != nil {
+				t.Errorf("createDatabase() error = %v", err)
+				return
+			}
+
+			err = db.AutoMigrate(Player{})
+			if err != nil {
+				t.Errorf("AutoMigrate() error = %v", err)
+				return
+			}
+
+			player := &Player{Name: "teste"}
+			db.Create(player)
+			for i := 0; i < 2; i++ {
+				player.Name = "teste atualizado"
+				db.Updates(player)
+			}
+			err = db.Exec(tt.corrupt).Error
+			if err != nil {
+				t.Errorf("Exec() error = %v", err)
+				return
+			}
+
+			failOn(db, tt.pattern)
+			_, err = Repair(context.Background(), db, &Player{})
+			if !errors.Is(err, errFailingStatement) {
+				t.Errorf("Repair() error = %v, want %v", err, errFailingStatement)
+			}
+
+			inconsistencies, err := Check(context.Background(), db, &Player{})
+			if err != nil || len(inconsistencies) == 0 {
+				t.Errorf("Check() = %v, %v, want the repair rolled back", inconsistencies, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,176 @@
+package MegaGormAudit
+
+import (
+	"encoding/json"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"maps"
+	"reflect"
+	"time"
+)
+
+const (
+	JoinTableInsert = "insert"
+	JoinTableDelete = "delete"
+)
+
+const joinRowsKey = "megagormaudit:join_rows"
+
+// JoinTableChange records a row inserted in or deleted from one of the plugin JoinTables.
+type JoinTableChange struct {
+	ID        uint `gorm:"primarykey"`
+	CreatedAt time.Time
+	JoinTable string
+	Operation string
+	Keys      string //JSON object with the key columns of the row
+	Actor     string
+	Metadata  Metadata
+}
+
+func (JoinTableChange) TableName() string {
+	return "audit_join_table_changes"
+}
+
+func (a MegaGormAuditPlugin) isJoinTable(table string) bool {
+	for _, joinTable := range a.JoinTables {
+		if joinTable == table {
+			return true
+		}
+	}
+	return false
+}
+
+// beforeJoinInsert stores the rows inserted in a join table that aren't stored yet,
+// since links already stored are skipped by gorm.
+func (a MegaGormAuditPlugin) beforeJoinInsert(db *gorm.DB) {
+	if db.Error != nil || !a.isJoinTable(db.Statement.Table) {
+		return
+	}
+
+	var inserted []map[string]interface{}
+	for _, row := range joinRows(db.Statement) {
+		var count int64
+		if err := db.Session(&gorm.Session{NewDB: true}).Table(db.Statement.Table).Where(row).Count(&count).Error; err != nil {
+			db.AddError(err)
+			return
+		}
+		if count == 0 {
+			inserted = append(inserted, row)
+		}
+	}
+	db.Statement.Settings.Store(joinRowsKey, inserted)
+}
+
+// beforeJoinDelete stores the rows of a join table matching the conditions of the delete.
+func (a MegaGormAuditPlugin) beforeJoinDelete(db *gorm.DB) {
+	if db.Error != nil || !a.isJoinTable(db.Statement.Table) {
+		return
+	}
+
+	query := db.Session(&gorm.Session{NewDB: true}).Table(db.Statement.Table)
+	if where, ok := db.Statement.Clauses["WHERE"]; ok {
+		query = query.Clauses(where.Expression)
+	}
+	if db.Statement.Schema != nil && db.Statement.ReflectValue.Kind() == reflect.Struct {
+		for _, field := range db.Statement.Schema.PrimaryFields {
+			if value, zero := field.ValueOf(db.Statement.Context, db.Statement.ReflectValue); !zero {
+				query = query.Where(clause.Eq{Column: clause.Column{Name: field.DBName}, Value: value})
+			}
+		}
+	}
+
+	var deleted []map[string]interface{}
+	if err := query.Find(&deleted).Error; err != nil {
+		db.AddError(err)
+		return
+	}
+	db.Statement.Settings.Store(joinRowsKey, deleted)
+}
+
+// recordJoinRows returns a callback recording the join table rows stored by beforeJoinInsert or beforeJoinDelete.
+func recordJoinRows(operation string) func(db *gorm.DB) {
+	return func(db *gorm.DB) {
+		rows, ok := db.Statement.Settings.Load(joinRowsKey)
+		if !ok || db.Error != nil {
+			return
+		}
+
+		for _, row := range rows.([]map[string]interface{}) {
+			err := recordJoinTableChange(db, db.Statement.Table, operation, row, actorFromContext(db.Statement.Context))
+			if err != nil {
+				db.AddError(err)
+				return
+			}
+		}
+	}
+}
+
+// joinRows returns the key columns of the rows being inserted in a join table.
+func joinRows(stmt *gorm.Statement) []map[string]interface{} {
+	if rows := mapRows(stmt.Dest); rows != nil {
+		return copyRows(rows)
+	}
+
+	fields := stmt.Schema.PrimaryFields
+	if len(fields) == 0 {
+		fields = stmt.Schema.Fields
+	}
+
+	row := func(value reflect.Value) map[string]interface{} {
+		keys := map[string]interface{}{}
+		for _, field := range fields {
+			keys[field.DBName], _ = field.ValueOf(stmt.Context, value)
+		}
+		return keys
+	}
+
+	var rows []map[string]interface{}
+	switch stmt.ReflectValue.Kind() {
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < stmt.ReflectValue.Len(); i++ {
+			rows = append(rows, row(reflect.Indirect(stmt.ReflectValue.Index(i))))
+		}
+	case reflect.Struct:
+		rows = append(rows, row(stmt.ReflectValue))
+	}
+	return rows
+}
+
+// mapRows returns the rows of a create from maps, nil when dest isn't a map.
+func mapRows(dest interface{}) []map[string]interface{} {
+	switch dest := dest.(type) {
+	case map[string]interface{}:
+		return []map[string]interface{}{dest}
+	case *map[string]interface{}:
+		return []map[string]interface{}{*dest}
+	case []map[string]interface{}:
+		return dest
+	case *[]map[string]interface{}:
+		return *dest
+	}
+	return nil
+}
+
+// copyRows returns copies of rows, since gorm adds the inserted ID to the maps it creates.
+func copyRows(rows []map[string]interface{}) []map[string]interface{} {
+	copies := make([]map[string]interface{}, 0, len(rows))
+	for _, row := range rows {
+		copies = append(copies, maps.Clone(row))
+	}
+	return copies
+}
+
+func recordJoinTableChange(tx *gorm.DB, table, operation string, keys map[string]interface{}, actor string) error {
+	encoded, err := json.Marshal(keys)
+	if err != nil {
+		return err
+	}
+
+	return tx.Session(&gorm.Session{NewDB: true}).Create(&JoinTableChange{
+		JoinTable: table,
+		Operation: operation,
+		Keys:      string(encoded),
+		Actor:     actor,
+		Metadata:  pluginOf(tx).contextMetadata(tx.Statement.Context),
+	}).Error
+}
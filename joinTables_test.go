@@ -0,0 +1,239 @@
+package MegaGormAudit
+
+import (
+	"context"
+	"fmt"
+	"gorm.io/gorm"
+	"math"
+	"reflect"
+	"testing"
+)
+
+type userRole struct {
+	UserID uint `gorm:"primaryKey"`
+	RoleID uint `gorm:"primaryKey"`
+}
+
+func (userRole) TableName() string {
+	return "user_roles"
+}
+
+type userRoleLink struct {
+	UserID uint
+	RoleID uint
+}
+
+func (userRoleLink) TableName() string {
+	return "user_roles"
+}
+
+func TestAuditPlugin_JoinTables(t *testing.T) {
+
+	created := JoinTableChange{JoinTable: "user_roles", Operation: JoinTableInsert, Keys: `{"role_id":1,"user_id":1}`, Actor: "admin"}
+
+	joinTables := MegaGormAuditPlugin{JoinTables: []string{"user_roles"}}
+	inserted := func(roleID uint, actor string) JoinTableChange {
+		return JoinTableChange{JoinTable: "user_roles", Operation: JoinTableInsert, Keys: fmt.Sprintf(`{"role_id":%d,"user_id":1}`, roleID), Actor: actor}
+	}
+
+	tests := []struct {
+		name        string
+		plugin      MegaGormAuditPlugin
+		pattern     string
+		change      func(db *gorm.DB, user *User) error
+		wantErr     bool
+		wantChanges []JoinTableChange
+	}{
+		{
+			name:   "Success, record append with context actor",
+			plugin: MegaGormAuditPlugin{JoinTables: []string{"user_roles"}},
+			change: func(db *gorm.DB, user *User) error {
+				ctx := WithActor(context.Background(), "manager")
+				return db.WithContext(ctx).Model(user).Association("Roles").Append(&Role{Name: "editor"})
+			},
+			wantChanges: []JoinTableChange{
+				created,
+				{JoinTable: "user_roles", Operation: JoinTableInsert, Keys: `{"role_id":2,"user_id":1}`, Actor: "manager"},
+			},
+		},
+		{
+			name:   "Success, record delete",
+			plugin: MegaGormAuditPlugin{JoinTables: []string{"user_roles"}},
+			change: func(db *gorm.DB, user *User) error {
+				return Association(db, user, "Roles").Delete(&user.Roles[0])
+			},
+			wantChanges: []JoinTableChange{
+				created,
+				{JoinTable: "user_roles", Operation: JoinTableDelete, Keys: `{"role_id":1,"user_id":1}`, Actor: "admin"},
+			},
+		},
+		{
+			name:   "Success, record replace",
+			plugin: MegaGormAuditPlugin{JoinTables: []string{"user_roles"}},
+			change: func(db *gorm.DB, user *User) error {
+				return Association(db, user, "Roles").Replace(&Role{Name: "editor"})
+			},
+			wantChanges: []JoinTableChange{
+				created,
+				{JoinTable: "user_roles", Operation: JoinTableInsert, Keys: `{"role_id":2,"user_id":1}`, Actor: "admin"},
+				{JoinTable: "user_roles", Operation: JoinTableDelete, Keys: `{"role_id":1,"user_id":1}`, Actor: "admin"},
+			},
+		},
+		{
+			name:   "Success, record links carried to a new version",
+			plugin: MegaGormAuditPlugin{JoinTables: []string{"user_roles"}},
+			change: func(db *gorm.DB, user *User) error {
+				user.Name = "updated user"
+				return db.Updates(user).Error
+			},
+			wantChanges: []JoinTableChange{
+				created,
+				{JoinTable: "user_roles", Operation: JoinTableInsert, Keys: `{"role_id":1,"user_id":2}`, Actor: "admin"},
+			},
+		},
+		{
+			name:   "Success, ignore join tables not configured",
+			plugin: MegaGormAuditPlugin{},
+			change: func(db *gorm.DB, user *User) error {
+				return Association(db, user, "Roles").Clear()
+			},
+		},
+		{
+			name:   "Success, record inserts of maps",
+			plugin: joinTables,
+			change: func(db *gorm.DB, user *User) error {
+				return db.Transaction(func(tx *gorm.DB) error {
+					tx = tx.Table("user_roles")
+					return firstError(
+						tx.Create(map[string]interface{}{"user_id": 1, "role_id": 2}).Error,
+						tx.Create(&map[string]interface{}{"user_id": 1, "role_id": 3}).Error,
+						tx.Create([]map[string]interface{}{{"user_id": 1, "role_id": 4}}).Error,
+						tx.Create(&[]map[string]interface{}{{"user_id": 1, "role_id": 5}}).Error,
+					)
+				})
+			},
+			wantChanges: []JoinTableChange{created, inserted(2, ""), inserted(3, ""), inserted(4, ""), inserted(5, "")},
+		},
+		{
+			name:   "Success, record insert and delete of a join model",
+			plugin: joinTables,
+			change: func(db *gorm.DB, user *User) error {
+				if err := db.Create(&userRole{UserID: 1, RoleID: 2}).Error; err != nil {
+					return err
+				}
+				return db.Delete(&userRole{UserID: 1, RoleID: 1}).Error
+			},
+			wantChanges: []JoinTableChange{
+				created,
+				inserted(2, ""),
+				{JoinTable: "user_roles", Operation: JoinTableDelete, Keys: `{"role_id":1,"user_id":1}`},
+			},
+		},
+		{
+			name:   "Success, record insert of a join model without primary key",
+			plugin: joinTables,
+			change: func(db *gorm.DB, user *User) error {
+				return db.Create(&userRoleLink{UserID: 1, RoleID: 2}).Error
+			},
+			wantChanges: []JoinTableChange{created, inserted(2, "")},
+		},
+		{
+			name:   "Fail encoding keys",
+			plugin: joinTables,
+			change: func(db *gorm.DB, user *User) error {
+				return db.Table("user_roles").Create(map[string]interface{}{"user_id": 1, "role_id": math.NaN()}).Error
+			},
+			wantErr:     true,
+			wantChanges: []JoinTableChange{created},
+		},
+		{
+			name:    "Fail checking stored links",
+			plugin:  joinTables,
+			pattern: "^SELECT count\\(\\*\\) FROM `user_roles`",
+			change: func(db *gorm.DB, user *User) error {
+				return db.Create(&userRole{UserID: 1, RoleID: 2}).Error
+			},
+			wantErr:     true,
+			wantChanges: []JoinTableChange{created},
+		},
+		{
+			name:    "Fail loading deleted links",
+			plugin:  joinTables,
+			pattern: "^SELECT \\* FROM `user_roles`",
+			change: func(db *gorm.DB, user *User) error {
+				return Association(db, user, "Roles").Delete(&user.Roles[0])
+			},
+			wantErr:     true,
+			wantChanges: []JoinTableChange{created},
+		},
+		{
+			name:    "Fail recording deleted links",
+			plugin:  joinTables,
+			pattern: "^INSERT INTO `audit_join_table_changes`",
+			change: func(db *gorm.DB, user *User) error {
+				return Association(db, user, "Roles").Delete(&user.Roles[0])
+			},
+			wantErr:     true,
+			wantChanges: []JoinTableChange{created},
+		},
+		{
+			name:    "Fail recording links carried to a new version",
+			plugin:  joinTables,
+			pattern: "^INSERT INTO `audit_join_table_changes`",
+			change: func(db *gorm.DB, user *User) error {
+				user.Name = "updated user"
+				return db.Updates(user).Error
+			},
+			wantErr:     true,
+			wantChanges: []JoinTableChange{created},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, err := createDatabaseWithPlugin(tt.plugin)
+			if err != nil {
+				t.Errorf("createDatabase() error = %v", err)
+				return
+			}
+
+			err = db.AutoMigrate(User{}, Role{}, Device{}, JoinTableChange{})
+			if err != nil {
+				t.Errorf("AutoMigrate() error = %v", err)
+				return
+			}
+
+			user := &User{
+				AuditableModel: AuditableModel{LastChangedUser: "admin"},
+				Name:           "user",
+				Roles:          []Role{{Name: "admin"}},
+			}
+			err = db.Create(user).Error
+			if err != nil {
+				t.Errorf("Create() error = %v", err)
+				return
+			}
+
+			if tt.pattern != "" {
+				failOn(db, tt.pattern)
+			}
+			err = tt.change(db, user)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("change() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+
+			var changes []JoinTableChange
+			db.Order("id").Find(&changes)
+			if len(changes) != len(tt.wantChanges) {
+				t.Errorf("JoinTableChange = %v, want %v", changes, tt.wantChanges)
+				return
+			}
+			for i, change := range changes {
+				change.ID, change.CreatedAt = 0, tt.wantChanges[i].CreatedAt
+				if !reflect.DeepEqual(change, tt.wantChanges[i]) {
+					t.Errorf("JoinTableChange = %v, want %v", change, tt.wantChanges[i])
+				}
+			}
+		})
+	}
+}
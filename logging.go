@@ -0,0 +1,87 @@
+package MegaGormAudit
+
+import (
+	"context"
+	"gorm.io/gorm"
+	"log/slog"
+	"time"
+)
+
+const logStartKey = "megagormaudit:log_start"
+
+// logOperation logs an audited operation on entity started at start, or its failure when err is set.
+func (a MegaGormAuditPlugin) logOperation(ctx context.Context, operation, entity string, start time.Time, err error, attrs ...slog.Attr) {
+	if a.Logger == nil {
+		return
+	}
+
+	attrs = append([]slog.Attr{
+		slog.String("operation", operation),
+		slog.String("entity", entity),
+	}, attrs...)
+	attrs = append(attrs, slog.Duration("duration", time.Since(start)))
+	if err != nil {
+		a.Logger.LogAttrs(ctx, logLevel(a.FailureLogLevel, slog.LevelError), "audited "+operation+" failed", append(attrs, slog.Any("error", err))...)
+		return
+	}
+	a.Logger.LogAttrs(ctx, logLevel(a.LogLevel, slog.LevelInfo), "audited "+operation, attrs...)
+}
+
+// logLevel returns the level of leveler, or fallback when it's nil.
+func logLevel(leveler slog.Leveler, fallback slog.Level) slog.Level {
+	if leveler == nil {
+		return fallback
+	}
+	return leveler.Level()
+}
+
+// startLog records when a create or delete of an auditable model started.
+func (a MegaGormAuditPlugin) startLog(db *gorm.DB) {
+	if a.Logger != nil {
+		db.Statement.Settings.Store(logStartKey, time.Now())
+	}
+}
+
+func logStart(db *gorm.DB) (time.Time, bool) {
+	start, ok := db.Statement.Settings.Load(logStartKey)
+	if !ok || db.Statement.Schema == nil || !isAuditableSchema(db.Statement.Schema) {
+		return time.Time{}, false
+	}
+	return start.(time.Time), true
+}
+
+// logCreate logs the creation of auditable models, except the new versions of updates, logged with them.
+func (a MegaGormAuditPlugin) logCreate(db *gorm.DB) {
+	start, ok := logStart(db)
+	if _, version := db.Statement.Settings.Load(versionInsertKey); !ok || version {
+		return
+	}
+
+	var ids []uint
+	var actor string
+	for _, model := range reflectModels(db.Statement.ReflectValue) {
+		ids = append(ids, auditableModelOf(model).ID)
+		actor = actorOf(model)
+	}
+
+	a.logOperation(db.Statement.Context, EventCreate, db.Statement.Schema.Table, start, db.Error,
+		slog.Any("new_ids", ids), slog.String("actor", actor))
+}
+
+// logDelete logs the deletion of an auditable model, except the versions superseded by updates, logged with them.
+func (a MegaGormAuditPlugin) logDelete(db *gorm.DB) {
+	start, ok := logStart(db)
+	if _, superseding := db.Statement.Settings.Load(supersedingKey); !ok || superseding {
+		return
+	}
+
+	var ids []uint
+	var actor string
+	for _, model := range reflectModels(db.Statement.ReflectValue) {
+		ids = append(ids, auditableModelOf(model).ID)
+		actor = actorOf(model)
+	}
+
+	a.logOperation(db.Statement.Context, EventDelete, db.Statement.Schema.Table, start, db.Error,
+		slog.Any("old_ids", ids), slog.String("actor", actor))
+}
@@ -0,0 +1,144 @@
+package MegaGormAudit
+
+import (
+	"bytes"
+	"encoding/json"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"log/slog"
+	"reflect"
+	"testing"
+)
+
+func TestAuditPlugin_Logger(t *testing.T) {
+
+	tests := []struct {
+		name        string
+		plugin      MegaGormAuditPlugin
+		change      func(db *gorm.DB, contract *Contract) error
+		wantRecords []map[string]interface{}
+	}{
+		{
+			name: "Success, update logged",
+			change: func(db *gorm.DB, contract *Contract) error {
+				contract.Value = 20
+				return db.Updates(contract).Error
+			},
+			wantRecords: []map[string]interface{}{
+				{"level": "INFO", "msg": "audited update", "operation": "update", "entity": "contracts", "old_id": 1.0, "new_id": 2.0, "actor": "admin"},
+			},
+		},
+		{
+			name: "Success, upsert logged only as update",
+			change: func(db *gorm.DB, contract *Contract) error {
+				upserted := &Contract{AuditableModel: AuditableModel{ID: 1, LastChangedUser: "admin"}, TaxID: "1", Value: 20}
+				return db.Clauses(clause.OnConflict{UpdateAll: true}).Create(upserted).Error
+			},
+			wantRecords: []map[string]interface{}{
+				{"level": "INFO", "msg": "audited update", "operation": "update", "entity": "contracts", "old_id": 1.0, "new_id": 2.0, "actor": "admin"},
+			},
+		},
+		{
+			name: "Success, delete logged",
+			change: func(db *gorm.DB, contract *Contract) error {
+				return db.Delete(contract).Error
+			},
+			wantRecords: []map[string]interface{}{
+				{"level": "INFO", "msg": "audited delete", "operation": "delete", "entity": "contracts", "old_ids": []interface{}{1.0}, "actor": "admin"},
+			},
+		},
+		{
+			name:   "Success, create logged at the configured level",
+			plugin: MegaGormAuditPlugin{LogLevel: slog.LevelDebug},
+			change: func(db *gorm.DB, contract *Contract) error {
+				return db.Create(&Contract{AuditableModel: AuditableModel{LastChangedUser: "bob"}, TaxID: "2"}).Error
+			},
+			wantRecords: []map[string]interface{}{
+				{"level": "DEBUG", "msg": "audited create", "operation": "create", "entity": "contracts", "new_ids": []interface{}{2.0}, "actor": "bob"},
+			},
+		},
+		{
+			name: "Fail on update logged with its error",
+			change: func(db *gorm.DB, contract *Contract) error {
+				contract.TaxID = "2"
+				db.Updates(contract)
+				return nil
+			},
+			wantRecords: []map[string]interface{}{
+				{"level": "ERROR", "msg": "audited update failed", "operation": "update", "entity": "contracts", "old_id": 1.0, "new_id": 1.0, "actor": "admin",
+					"error": "megagormaudit: immutable fields can't be changed: TaxID"},
+			},
+		},
+		{
+			name:   "Fail on update logged at the configured level",
+			plugin: MegaGormAuditPlugin{FailureLogLevel: slog.LevelWarn},
+			change: func(db *gorm.DB, contract *Contract) error {
+				contract.TaxID = "2"
+				db.Updates(contract)
+				return nil
+			},
+			wantRecords: []map[string]interface{}{
+				{"level": "WARN", "msg": "audited update failed", "operation": "update", "entity": "contracts", "old_id": 1.0, "new_id": 1.0, "actor": "admin",
+					"error": "megagormaudit: immutable fields can't be changed: TaxID"},
+			},
+		},
+		{
+			name:   "Fail on update logged at the configured info level",
+			plugin: MegaGormAuditPlugin{FailureLogLevel: slog.LevelInfo},
+			change: func(db *gorm.DB, contract *Contract) error {
+				contract.TaxID = "2"
+				db.Updates(contract)
+				return nil
+			},
+			wantRecords: []map[string]interface{}{
+				{"level": "INFO", "msg": "audited update failed", "operation": "update", "entity": "contracts", "old_id": 1.0, "new_id": 1.0, "actor": "admin",
+					"error": "megagormaudit: immutable fields can't be changed: TaxID"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var output bytes.Buffer
+			tt.plugin.Logger = slog.New(slog.NewJSONHandler(&output, &slog.HandlerOptions{Level: slog.LevelDebug}))
+			db, err := createDatabaseWithPlugin(tt.plugin)
+			if err != nil {
+				t.Errorf("createDatabase() error = %v", err)
+				return
+			}
+
+			err = db.AutoMigrate(Contract{})
+			if err != nil {
+				t.Errorf("AutoMigrate() error = %v", err)
+				return
+			}
+
+			contract := &Contract{AuditableModel: AuditableModel{LastChangedUser: "admin"}, TaxID: "1", Value: 10}
+			db.Create(contract)
+			output.Reset()
+
+			if err := tt.change(db, contract); err != nil {
+				t.Errorf("change() error = %v", err)
+				return
+			}
+
+			records := []map[string]interface{}{}
+			decoder := json.NewDecoder(&output)
+			for decoder.More() {
+				var record map[string]interface{}
+				if err := decoder.Decode(&record); err != nil {
+					t.Errorf("Decode() error = %v", err)
+					return
+				}
+				if _, ok := record["duration"]; !ok {
+					t.Errorf("record %v without duration", record)
+				}
+				delete(record, "time")
+				delete(record, "duration")
+				records = append(records, record)
+			}
+			if !reflect.DeepEqual(records, tt.wantRecords) {
+				t.Errorf("records = %v, want %v", records, tt.wantRecords)
+			}
+		})
+	}
+}
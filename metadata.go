@@ -0,0 +1,98 @@
+package MegaGormAudit
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"gorm.io/gorm"
+	"reflect"
+)
+
+const (
+	MetadataClientIP  = "client_ip"
+	MetadataUserAgent = "user_agent"
+	MetadataRequestID = "request_id"
+	MetadataService   = "service"
+	MetadataHostname  = "hostname"
+)
+
+// Metadata holds information about the origin of a change, stored as a JSON object.
+type Metadata map[string]string
+
+func (Metadata) GormDataType() string {
+	return "text"
+}
+
+func (m Metadata) Value() (driver.Value, error) {
+	if m == nil {
+		return nil, nil
+	}
+	encoded, err := json.Marshal(m)
+	return string(encoded), err
+}
+
+func (m *Metadata) Scan(value interface{}) error {
+	*m = nil //keys of a previous value aren't kept
+	switch value := value.(type) {
+	case nil:
+		return nil
+	case string:
+		return json.Unmarshal([]byte(value), m)
+	case []byte:
+		return json.Unmarshal(value, m)
+	}
+	return fmt.Errorf("megagormaudit: can't scan %T into Metadata", value)
+}
+
+type metadataKey struct{}
+
+// WithMetadata returns a copy of ctx carrying the metadata key with value, stored with the versions
+// and audit log rows of the changes made with it.
+func WithMetadata(ctx context.Context, key, value string) context.Context {
+	metadata := Metadata{}
+	for k, v := range metadataFromContext(ctx) {
+		metadata[k] = v
+	}
+	metadata[key] = value
+	return context.WithValue(ctx, metadataKey{}, metadata)
+}
+
+func metadataFromContext(ctx context.Context) Metadata {
+	metadata, _ := ctx.Value(metadataKey{}).(Metadata)
+	return metadata
+}
+
+// contextMetadata returns the metadata of ctx with the plugin MetadataKeys, or all of them when none are configured.
+func (a MegaGormAuditPlugin) contextMetadata(ctx context.Context) Metadata {
+	metadata := metadataFromContext(ctx)
+	if len(a.MetadataKeys) == 0 || metadata == nil {
+		return metadata
+	}
+
+	filtered := Metadata{}
+	for _, key := range a.MetadataKeys {
+		if value, ok := metadata[key]; ok {
+			filtered[key] = value
+		}
+	}
+	if len(filtered) == 0 {
+		return nil
+	}
+	return filtered
+}
+
+// clearInheritedMetadata clears the AuditMetadata model, a loaded or previously saved version, carries from previous,
+// the stored version, when ctx has no metadata, so metadata is only recorded by the change made with it.
+func (a MegaGormAuditPlugin) clearInheritedMetadata(ctx context.Context, model *AuditableModel, previous interface{}) {
+	if a.contextMetadata(ctx) == nil && reflect.DeepEqual(model.AuditMetadata, auditableModelOf(previous).AuditMetadata) {
+		model.AuditMetadata = nil
+	}
+}
+
+// stampMetadata sets the AuditMetadata of the auditable models created with the metadata of the statement context.
+func (a MegaGormAuditPlugin) stampMetadata(db *gorm.DB) {
+	if metadata := a.contextMetadata(db.Statement.Context); metadata != nil {
+		stampAuditableField(db, "AuditMetadata", metadata)
+	}
+}
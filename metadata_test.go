@@ -0,0 +1,208 @@
+package MegaGormAudit
+
+import (
+	"context"
+	"gorm.io/gorm"
+	"reflect"
+	"testing"
+)
+
+func TestAuditPlugin_Metadata(t *testing.T) {
+
+	ctx := WithMetadata(WithMetadata(context.Background(), MetadataClientIP, "10.0.0.1"), MetadataRequestID, "req-1")
+
+	tests := []struct {
+		name         string
+		plugin       MegaGormAuditPlugin
+		change       func(db *gorm.DB, account *Account) error
+		wantMetadata []Metadata
+	}{
+		{
+			name: "Success, metadata from context on update",
+			change: func(db *gorm.DB, account *Account) error {
+				account.Balance = 20
+				return db.WithContext(ctx).Updates(account).Error
+			},
+			wantMetadata: []Metadata{
+				{MetadataClientIP: "10.0.0.1", MetadataRequestID: "req-1"},
+				{MetadataClientIP: "10.0.0.1", MetadataRequestID: "req-1"},
+			},
+		},
+		{
+			name: "Success, metadata from context on delete",
+			change: func(db *gorm.DB, account *Account) error {
+				return db.WithContext(ctx).Delete(account).Error
+			},
+			wantMetadata: []Metadata{{MetadataClientIP: "10.0.0.1", MetadataRequestID: "req-1"}},
+		},
+		{
+			name: "Success, metadata of a previous change isn't inherited on update",
+			change: func(db *gorm.DB, account *Account) error {
+				account.Balance = 20
+				if err := db.WithContext(ctx).Updates(account).Error; err != nil {
+					return err
+				}
+
+				var loaded Account
+				db.Take(&loaded)
+				loaded.Balance = 30
+				return db.Updates(&loaded).Error
+			},
+			wantMetadata: []Metadata{{MetadataClientIP: "10.0.0.1", MetadataRequestID: "req-1"}, nil, nil},
+		},
+		{
+			name: "Success, metadata of a previous change isn't inherited on delete",
+			change: func(db *gorm.DB, account *Account) error {
+				account.Balance = 20
+				if err := db.WithContext(ctx).Updates(account).Error; err != nil {
+					return err
+				}
+
+				var loaded Account
+				db.Take(&loaded)
+				return db.Delete(&loaded).Error
+			},
+			wantMetadata: []Metadata{{MetadataClientIP: "10.0.0.1", MetadataRequestID: "req-1"}, nil},
+		},
+		{
+			name: "Success, metadata from model on update",
+			change: func(db *gorm.DB, account *Account) error {
+				account.Balance = 20
+				account.AuditMetadata = Metadata{MetadataService: "billing"}
+				return db.Updates(account).Error
+			},
+			wantMetadata: []Metadata{{MetadataService: "billing"}, {MetadataService: "billing"}},
+		},
+		{
+			name: "Success, metadata from context on create",
+			change: func(db *gorm.DB, account *Account) error {
+				return db.WithContext(ctx).Create(&Account{Owner: "bob"}).Error
+			},
+			wantMetadata: []Metadata{nil, {MetadataClientIP: "10.0.0.1", MetadataRequestID: "req-1"}},
+		},
+		{
+			name:   "Success, only configured metadata keys",
+			plugin: MegaGormAuditPlugin{MetadataKeys: []string{MetadataRequestID, MetadataHostname}},
+			change: func(db *gorm.DB, account *Account) error {
+				return db.WithContext(ctx).Delete(account).Error
+			},
+			wantMetadata: []Metadata{{MetadataRequestID: "req-1"}},
+		},
+		{
+			name:   "Success, no metadata without configured keys in context",
+			plugin: MegaGormAuditPlugin{MetadataKeys: []string{MetadataHostname}},
+			change: func(db *gorm.DB, account *Account) error {
+				return db.WithContext(ctx).Delete(account).Error
+			},
+			wantMetadata: []Metadata{nil},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, err := createDatabaseWithPlugin(tt.plugin)
+			if err != nil {
+				t.Errorf("createDatabase() error = %v", err)
+				return
+			}
+
+			err = db.AutoMigrate(Account{})
+			if err != nil {
+				t.Errorf("AutoMigrate() error = %v", err)
+				return
+			}
+
+			account := &Account{Owner: "alice", Balance: 10}
+			db.Create(account)
+
+			err = tt.change(db, account)
+			if err != nil {
+				t.Errorf("change() error = %v", err)
+				return
+			}
+
+			var accounts []Account
+			db.Unscoped().Order("id").Find(&accounts)
+			metadata := make([]Metadata, 0, len(accounts))
+			for _, account := range accounts {
+				metadata = append(metadata, account.AuditMetadata)
+			}
+			if !reflect.DeepEqual(metadata, tt.wantMetadata) {
+				t.Errorf("metadata = %v, want %v", metadata, tt.wantMetadata)
+			}
+		})
+	}
+}
+
+func TestAuditPlugin_MetadataAssociations(t *testing.T) {
+	db, err := createDatabaseWithPlugin(MegaGormAuditPlugin{LogAssociations: true})
+	if err != nil {
+		t.Errorf("createDatabase() error = %v", err)
+		return
+	}
+
+	err = db.AutoMigrate(User{}, Role{}, Device{}, AssociationChange{})
+	if err != nil {
+		t.Errorf("AutoMigrate() error = %v", err)
+		return
+	}
+
+	user := &User{AuditableModel: AuditableModel{LastChangedUser: "admin"}, Name: "user"}
+	db.Create(user)
+
+	ctx := WithMetadata(context.Background(), MetadataUserAgent, "curl/8.0")
+	err = Association(db.WithContext(ctx), user, "Roles").Append(&Role{Name: "editor"})
+	if err != nil {
+		t.Errorf("Append() error = %v", err)
+		return
+	}
+
+	var changes []AssociationChange
+	db.Find(&changes)
+	want := Metadata{MetadataUserAgent: "curl/8.0"}
+	if len(changes) != 1 || !reflect.DeepEqual(changes[0].Metadata, want) {
+		t.Errorf("AssociationChange = %v, want metadata %v", changes, want)
+	}
+}
+
+func TestMetadata_Scan(t *testing.T) {
+
+	tests := []struct {
+		name    string
+		value   interface{}
+		want    Metadata
+		wantErr bool
+	}{
+		{
+			name:  "Success, string",
+			value: `{"client_ip":"10.0.0.1"}`,
+			want:  Metadata{MetadataClientIP: "10.0.0.1"},
+		},
+		{
+			name:  "Success, bytes",
+			value: []byte(`{"client_ip":"10.0.0.1"}`),
+			want:  Metadata{MetadataClientIP: "10.0.0.1"},
+		},
+		{
+			name:  "Success, null",
+			value: nil,
+		},
+		{
+			name:    "Fail on unsupported type",
+			value:   10,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			metadata := Metadata{MetadataService: "previous"}
+			err := metadata.Scan(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Scan() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !tt.wantErr && !reflect.DeepEqual(metadata, tt.want) {
+				t.Errorf("Scan() = %v, want %v", metadata, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,79 @@
+package MegaGormAudit
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+const defaultRequestIDHeader = "X-Request-ID"
+
+// MiddlewareConfig configures how Middleware extracts the audit context from a request.
+type MiddlewareConfig struct {
+	Actor           func(r *http.Request) string //returns the user responsible for the request, usually from its authentication
+	RequestIDHeader string                       //header with the request ID, X-Request-ID when empty
+	ClientIPHeader  string                       //header with the client IP set by a trusted proxy, such as X-Forwarded-For; the remote address when empty
+	TrustedProxies  int                          //trusted proxies appending to ClientIPHeader, the client IP is the entry this many positions from the right; 1 when zero
+	Service         string                       //name of the service stored with the metadata
+}
+
+// Middleware returns a net/http middleware storing the actor, request ID, client IP and user agent of each request
+// in its context, so changes made with db.WithContext(r.Context()) are attributed to them.
+func Middleware(config MiddlewareConfig) func(http.Handler) http.Handler {
+	requestIDHeader := config.RequestIDHeader
+	if requestIDHeader == "" {
+		requestIDHeader = defaultRequestIDHeader
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := r.Context()
+			if config.Actor != nil {
+				if actor := config.Actor(r); actor != "" {
+					ctx = WithActor(ctx, actor)
+				}
+			}
+
+			if requestID := r.Header.Get(requestIDHeader); requestID != "" {
+				ctx = WithMetadata(ctx, MetadataRequestID, requestID)
+			}
+			if ip := clientIP(r, config.ClientIPHeader, config.TrustedProxies); ip != "" {
+				ctx = WithMetadata(ctx, MetadataClientIP, ip)
+			}
+			if userAgent := r.UserAgent(); userAgent != "" {
+				ctx = WithMetadata(ctx, MetadataUserAgent, userAgent)
+			}
+			if config.Service != "" {
+				ctx = WithMetadata(ctx, MetadataService, config.Service)
+			}
+
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// clientIP returns the address of the header appended by the first of the trusted proxies, counting from the right,
+// since the entries on its left are sent by the client and can be spoofed. Without the header, or when it has fewer
+// entries than trusted proxies, it returns the host of the request remote address.
+func clientIP(r *http.Request, header string, trustedProxies int) string {
+	if trustedProxies <= 0 {
+		trustedProxies = 1
+	}
+	if header != "" {
+		var entries []string
+		for _, value := range r.Header.Values(header) {
+			entries = append(entries, strings.Split(value, ",")...)
+		}
+		if len(entries) >= trustedProxies {
+			if ip := strings.TrimSpace(entries[len(entries)-trustedProxies]); ip != "" {
+				return ip
+			}
+		}
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
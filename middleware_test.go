@@ -0,0 +1,152 @@
+package MegaGormAudit
+
+import (
+	"gorm.io/gorm"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestMiddleware(t *testing.T) {
+
+	actor := func(r *http.Request) string {
+		return r.Header.Get("X-User")
+	}
+
+	tests := []struct {
+		name         string
+		config       MiddlewareConfig
+		header       map[string]string
+		remoteAddr   string
+		handle       func(r *http.Request, db *gorm.DB, account *Account) error
+		wantActors   []string
+		wantMetadata Metadata
+	}{
+		{
+			name:   "Success, context actor and metadata on update",
+			config: MiddlewareConfig{Actor: actor, Service: "billing"},
+			header: map[string]string{"X-User": "alice", "X-Request-ID": "req-1", "User-Agent": "curl/8.0"},
+			handle: func(r *http.Request, db *gorm.DB, account *Account) error {
+				account.Balance = 20
+				return db.WithContext(r.Context()).Updates(account).Error
+			},
+			wantActors: []string{"alice", "alice"},
+			wantMetadata: Metadata{
+				MetadataRequestID: "req-1", MetadataClientIP: "192.0.2.1", MetadataUserAgent: "curl/8.0", MetadataService: "billing",
+			},
+		},
+		{
+			name:   "Success, context actor overrides the model on delete",
+			config: MiddlewareConfig{Actor: actor, RequestIDHeader: "X-Correlation-ID", ClientIPHeader: "X-Forwarded-For"},
+			header: map[string]string{"X-User": "bob", "X-Correlation-ID": "corr-1", "X-Forwarded-For": "203.0.113.7, 10.0.0.1"},
+			handle: func(r *http.Request, db *gorm.DB, account *Account) error {
+				account.LastChangedUser = "system"
+				return db.WithContext(r.Context()).Delete(account).Error
+			},
+			wantActors:   []string{"bob"},
+			wantMetadata: Metadata{MetadataRequestID: "corr-1", MetadataClientIP: "10.0.0.1"},
+		},
+		{
+			name:   "Success, client IP appended by the first of the trusted proxies",
+			config: MiddlewareConfig{ClientIPHeader: "X-Forwarded-For", TrustedProxies: 2},
+			header: map[string]string{"X-Forwarded-For": "198.51.100.9, 203.0.113.7, 10.0.0.1"},
+			handle: func(r *http.Request, db *gorm.DB, account *Account) error {
+				return db.WithContext(r.Context()).Delete(account).Error
+			},
+			wantActors:   []string{""},
+			wantMetadata: Metadata{MetadataClientIP: "203.0.113.7"},
+		},
+		{
+			name:   "Success, remote address with fewer entries than trusted proxies",
+			config: MiddlewareConfig{ClientIPHeader: "X-Forwarded-For", TrustedProxies: 2},
+			header: map[string]string{"X-Forwarded-For": "203.0.113.7"},
+			handle: func(r *http.Request, db *gorm.DB, account *Account) error {
+				return db.WithContext(r.Context()).Delete(account).Error
+			},
+			wantActors:   []string{""},
+			wantMetadata: Metadata{MetadataClientIP: "192.0.2.1"},
+		},
+		{
+			name:       "Success, remote address without port",
+			config:     MiddlewareConfig{},
+			remoteAddr: "192.0.2.10",
+			handle: func(r *http.Request, db *gorm.DB, account *Account) error {
+				return db.WithContext(r.Context()).Delete(account).Error
+			},
+			wantActors:   []string{""},
+			wantMetadata: Metadata{MetadataClientIP: "192.0.2.10"},
+		},
+		{
+			name:   "Success, context actor on create",
+			config: MiddlewareConfig{Actor: actor},
+			header: map[string]string{"X-User": "carol"},
+			handle: func(r *http.Request, db *gorm.DB, account *Account) error {
+				return db.WithContext(r.Context()).Create(&Account{Owner: "bob"}).Error
+			},
+			wantActors:   []string{"", "carol"},
+			wantMetadata: Metadata{MetadataClientIP: "192.0.2.1"},
+		},
+		{
+			name:   "Success, model actor without actor in the request",
+			config: MiddlewareConfig{Actor: actor},
+			handle: func(r *http.Request, db *gorm.DB, account *Account) error {
+				account.LastChangedUser = "system"
+				return db.WithContext(r.Context()).Delete(account).Error
+			},
+			wantActors:   []string{"system"},
+			wantMetadata: Metadata{MetadataClientIP: "192.0.2.1"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, err := createDatabase()
+			if err != nil {
+				t.Errorf("createDatabase() error = %v", err)
+				return
+			}
+
+			err = db.AutoMigrate(Account{})
+			if err != nil {
+				t.Errorf("AutoMigrate() error = %v", err)
+				return
+			}
+
+			account := &Account{Owner: "alice", Balance: 10}
+			db.Create(account)
+
+			handler := Middleware(tt.config)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if err := tt.handle(r, db, account); err != nil {
+					w.WriteHeader(http.StatusInternalServerError)
+				}
+			}))
+
+			request := httptest.NewRequest(http.MethodPost, "/accounts", nil)
+			for key, value := range tt.header {
+				request.Header.Set(key, value)
+			}
+			if tt.remoteAddr != "" {
+				request.RemoteAddr = tt.remoteAddr
+			}
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, request)
+			if recorder.Code != http.StatusOK {
+				t.Errorf("ServeHTTP() status = %v, want %v", recorder.Code, http.StatusOK)
+				return
+			}
+
+			var accounts []Account
+			db.Unscoped().Order("id").Find(&accounts)
+			actors := make([]string, 0, len(accounts))
+			for _, account := range accounts {
+				actors = append(actors, account.LastChangedUser)
+			}
+			if !reflect.DeepEqual(actors, tt.wantActors) {
+				t.Errorf("actors = %v, want %v", actors, tt.wantActors)
+			}
+			if last := accounts[len(accounts)-1].AuditMetadata; !reflect.DeepEqual(last, tt.wantMetadata) {
+				t.Errorf("metadata = %v, want %v", last, tt.wantMetadata)
+			}
+		})
+	}
+}
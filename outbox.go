@@ -0,0 +1,190 @@
+package MegaGormAudit
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+	"reflect"
+	"time"
+)
+
+const (
+	EventCreate = "create"
+	EventUpdate = "update"
+	EventDelete = "delete"
+)
+
+const versionInsertKey = "megagormaudit:version_insert"
+
+// AuditEvent describes an audited change of an auditable model.
+type AuditEvent struct {
+	ID           uint                   `json:"id"`             //ID of the outbox event, repeated when the event is delivered again, 0 without Outbox
+	Entity       string                 `json:"entity"`         //table of the auditable model
+	Operation    string                 `json:"operation"`      //EventCreate, EventUpdate or EventDelete
+	EntityID     uint                   `json:"entity_id"`      //ID of the original version
+	OldVersionID uint                   `json:"old_version_id"` //superseded or deleted version, 0 on creates
+	NewVersionID uint                   `json:"new_version_id"` //created version, 0 on deletes
+	Actor        string                 `json:"actor"`
+	Reason       string                 `json:"reason,omitempty"`
+	Metadata     Metadata               `json:"metadata,omitempty"`
+	Diff         map[string]FieldChange `json:"diff,omitempty"` //changed columns, sensitive fields are listed without values
+	OccurredAt   time.Time              `json:"occurred_at"`
+}
+
+// FieldChange holds the old and new values of a changed column.
+type FieldChange struct {
+	Old interface{} `json:"old"`
+	New interface{} `json:"new"`
+}
+
+// OutboxEvent stores an AuditEvent in the transaction of the change, when the plugin Outbox is enabled,
+// until it is delivered by a Relay.
+type OutboxEvent struct {
+	ID          uint `gorm:"primarykey"`
+	CreatedAt   time.Time
+	Entity      string
+	Operation   string
+	Payload     string //JSON AuditEvent
+	Attempts    int
+	LastError   string
+	DeliveredAt *time.Time `gorm:"index"`
+	ParkedAt    *time.Time `gorm:"index"` //set when the event reaches the Relay MaxAttempts, it's no longer delivered
+}
+
+func (OutboxEvent) TableName() string {
+	return "audit_outbox_events"
+}
+
+// auditEvent returns the event of an operation on the auditable schema from the version old to new, either can be nil.
+func auditEvent(tx *gorm.DB, auditSchema *schema.Schema, operation string, old, new interface{}) AuditEvent {
+	event := AuditEvent{
+		Entity:     auditSchema.Table,
+		Operation:  operation,
+		Diff:       diff(tx, auditSchema, old, new),
+		OccurredAt: tx.NowFunc(),
+	}
+
+	current := new
+	if old != nil {
+		event.OldVersionID = auditableModelOf(old).ID
+		current = old
+	}
+	if new != nil {
+		event.NewVersionID = auditableModelOf(new).ID
+		current = new
+	}
+
+	auditableModel := auditableModelOf(current)
+	event.EntityID = auditableModel.ID
+	if auditableModel.AuditParentID != nil {
+		event.EntityID = *auditableModel.AuditParentID
+	}
+	event.Actor = auditableModel.LastChangedUser
+	event.Reason = auditableModel.ChangeReason
+	event.Metadata = auditableModel.AuditMetadata
+	return event
+}
+
+// diff returns the columns of the auditable schema changed from the version old to new, either can be nil.
+func diff(tx *gorm.DB, auditSchema *schema.Schema, old, new interface{}) map[string]FieldChange {
+	changes := map[string]FieldChange{}
+	for _, field := range auditSchema.Fields {
+		if field.DBName == "" || isAuditableModelField(field) {
+			continue
+		}
+
+		var change FieldChange
+		if old != nil {
+			change.Old = columnValue(tx, field, old)
+		}
+		if new != nil {
+			change.New = columnValue(tx, field, new)
+		}
+		if old != nil && new != nil && hashValue(change.Old) == hashValue(change.New) {
+			continue
+		}
+
+		if redaction(field) != "" {
+			change = FieldChange{}
+		}
+		changes[field.DBName] = change
+	}
+
+	if len(changes) == 0 {
+		return nil
+	}
+	return changes
+}
+
+func columnValue(tx *gorm.DB, field *schema.Field, model interface{}) interface{} {
+	value, _ := field.ValueOf(tx.Statement.Context, reflect.Indirect(reflect.ValueOf(model)))
+	if valuer, ok := value.(driver.Valuer); ok {
+		value, _ = valuer.Value()
+	}
+	return value
+}
+
+// emitsEvents reports whether the plugin records or delivers audit events.
+func (a MegaGormAuditPlugin) emitsEvents() bool {
+	return a.Outbox || a.Events != nil
+}
+
+// emit records the event in the outbox, in the transaction tx, and queues it in the event stream.
+func (a MegaGormAuditPlugin) emit(tx *gorm.DB, event AuditEvent) error {
+	if a.Outbox {
+		payload, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+
+		outboxEvent := &OutboxEvent{Entity: event.Entity, Operation: event.Operation, Payload: string(payload)}
+		if err := tx.Session(&gorm.Session{NewDB: true}).Create(outboxEvent).Error; err != nil {
+			return err
+		}
+		event.ID = outboxEvent.ID
+	}
+
+	if a.Events != nil {
+		a.Events.queue(tx, event)
+	}
+	return nil
+}
+
+// createEvents emits the events of the auditable models created, except the new versions of updates.
+func (a MegaGormAuditPlugin) createEvents(db *gorm.DB) {
+	if !a.emitsEvents() || db.Error != nil || db.Statement.Schema == nil || !isAuditableSchema(db.Statement.Schema) {
+		return
+	}
+	if _, version := db.Statement.Settings.Load(versionInsertKey); version {
+		return
+	}
+
+	for _, model := range reflectModels(db.Statement.ReflectValue) {
+		if auditableModelOf(model).ID == 0 {
+			continue
+		}
+		if err := a.emit(db, auditEvent(db, db.Statement.Schema, EventCreate, nil, model)); err != nil {
+			db.AddError(err)
+			return
+		}
+	}
+}
+
+// reflectModels returns pointers to the models of a statement reflect value, a struct or a slice of them.
+func reflectModels(value reflect.Value) []interface{} {
+	var models []interface{}
+	switch value.Kind() {
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < value.Len(); i++ {
+			if model := reflect.Indirect(value.Index(i)); model.CanAddr() {
+				models = append(models, model.Addr().Interface())
+			}
+		}
+	case reflect.Struct:
+		if value.CanAddr() {
+			models = append(models, value.Addr().Interface())
+		}
+	}
+	return models
+}
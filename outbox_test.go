@@ -0,0 +1,387 @@
+package MegaGormAudit
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+)
+
+var errRolledBack = errors.New("rolled back")
+
+func TestAuditPlugin_Outbox(t *testing.T) {
+
+	tests := []struct {
+		name       string
+		plugin     MegaGormAuditPlugin
+		pattern    string
+		change     func(db *gorm.DB, account *Account) error
+		wantErr    error
+		wantEvents []AuditEvent
+	}{
+		{
+			name:   "Success, events of create and update",
+			plugin: MegaGormAuditPlugin{Outbox: true},
+			change: func(db *gorm.DB, account *Account) error {
+				account.Balance = 20
+				return db.WithContext(WithReason(context.Background(), "deposit")).Updates(account).Error
+			},
+			wantEvents: []AuditEvent{
+				{Entity: "accounts", Operation: EventCreate, EntityID: 1, NewVersionID: 1, Actor: "admin",
+					Diff: map[string]FieldChange{"owner": {New: "alice"}, "balance": {New: float64(10)}}},
+				{Entity: "accounts", Operation: EventUpdate, EntityID: 1, OldVersionID: 1, NewVersionID: 2, Actor: "admin", Reason: "deposit",
+					Diff: map[string]FieldChange{"balance": {Old: float64(10), New: float64(20)}}},
+			},
+		},
+		{
+			name:   "Success, event of delete",
+			plugin: MegaGormAuditPlugin{Outbox: true},
+			change: func(db *gorm.DB, account *Account) error {
+				ctx := WithMetadata(WithActor(context.Background(), "bob"), MetadataRequestID, "req-1")
+				return db.WithContext(ctx).Delete(account).Error
+			},
+			wantEvents: []AuditEvent{
+				{Entity: "accounts", Operation: EventCreate, EntityID: 1, NewVersionID: 1, Actor: "admin",
+					Diff: map[string]FieldChange{"owner": {New: "alice"}, "balance": {New: float64(10)}}},
+				{Entity: "accounts", Operation: EventDelete, EntityID: 1, OldVersionID: 1, Actor: "bob", Metadata: Metadata{MetadataRequestID: "req-1"},
+					Diff: map[string]FieldChange{"owner": {Old: "alice"}, "balance": {Old: float64(10)}}},
+			},
+		},
+		{
+			name:   "Success, only event of update on upsert superseding the live version",
+			plugin: MegaGormAuditPlugin{Outbox: true},
+			change: func(db *gorm.DB, account *Account) error {
+				upserted := &Account{AuditableModel: AuditableModel{ID: 1, LastChangedUser: "admin"}, Owner: "alice", Balance: 20}
+				return db.Clauses(clause.OnConflict{UpdateAll: true}).Create(upserted).Error
+			},
+			wantEvents: []AuditEvent{
+				{Entity: "accounts", Operation: EventCreate, EntityID: 1, NewVersionID: 1, Actor: "admin",
+					Diff: map[string]FieldChange{"owner": {New: "alice"}, "balance": {New: float64(10)}}},
+				{Entity: "accounts", Operation: EventUpdate, EntityID: 1, OldVersionID: 1, NewVersionID: 2, Actor: "admin",
+					Diff: map[string]FieldChange{"balance": {Old: float64(10), New: float64(20)}}},
+			},
+		},
+		{
+			name: "Success, no events without outbox",
+			change: func(db *gorm.DB, account *Account) error {
+				account.Balance = 20
+				return db.Updates(account).Error
+			},
+		},
+		{
+			name:   "Fail on rolled back update without its event",
+			plugin: MegaGormAuditPlugin{Outbox: true},
+			change: func(db *gorm.DB, account *Account) error {
+				return db.Transaction(func(tx *gorm.DB) error {
+					account.Balance = 20
+					if err := tx.Updates(account).Error; err != nil {
+						return err
+					}
+					return errRolledBack
+				})
+			},
+			wantErr: errRolledBack,
+			wantEvents: []AuditEvent{
+				{Entity: "accounts", Operation: EventCreate, EntityID: 1, NewVersionID: 1, Actor: "admin",
+					Diff: map[string]FieldChange{"owner": {New: "alice"}, "balance": {New: float64(10)}}},
+			},
+		},
+		{
+			name:    "Fail recording event of update",
+			plugin:  MegaGormAuditPlugin{Outbox: true},
+			pattern: "^INSERT INTO `audit_outbox_events`",
+			change: func(db *gorm.DB, account *Account) error {
+				account.Balance = 20
+				return db.Updates(account).Error
+			},
+			wantErr: errFailingStatement,
+			wantEvents: []AuditEvent{
+				{Entity: "accounts", Operation: EventCreate, EntityID: 1, NewVersionID: 1, Actor: "admin",
+					Diff: map[string]FieldChange{"owner": {New: "alice"}, "balance": {New: float64(10)}}},
+			},
+		},
+		{
+			name:    "Fail recording event of create",
+			plugin:  MegaGormAuditPlugin{Outbox: true},
+			pattern: "^INSERT INTO `audit_outbox_events`",
+			change: func(db *gorm.DB, account *Account) error {
+				return db.Create(&Account{Owner: "bob"}).Error
+			},
+			wantErr: errFailingStatement,
+			wantEvents: []AuditEvent{
+				{Entity: "accounts", Operation: EventCreate, EntityID: 1, NewVersionID: 1, Actor: "admin",
+					Diff: map[string]FieldChange{"owner": {New: "alice"}, "balance": {New: float64(10)}}},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, err := createDatabaseWithPlugin(tt.plugin)
+			if err != nil {
+				t.Errorf("createDatabase() error = %v", err)
+				return
+			}
+
+			err = db.AutoMigrate(Account{}, OutboxEvent{})
+			if err != nil {
+				t.Errorf("AutoMigrate() error = %v", err)
+				return
+			}
+
+			account := &Account{AuditableModel: AuditableModel{LastChangedUser: "admin"}, Owner: "alice", Balance: 10}
+			if err := db.Create(account).Error; err != nil {
+				t.Errorf("Create() error = %v", err)
+				return
+			}
+
+			if tt.pattern != "" {
+				failOn(db, tt.pattern)
+			}
+			err = tt.change(db, account)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("change() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+
+			var outboxEvents []OutboxEvent
+			db.Order("id").Find(&outboxEvents)
+			events := []AuditEvent{}
+			for _, outboxEvent := range outboxEvents {
+				var event AuditEvent
+				if err := json.Unmarshal([]byte(outboxEvent.Payload), &event); err != nil {
+					t.Errorf("Unmarshal() error = %v", err)
+					return
+				}
+				event.OccurredAt = tt.wantEvents[len(events)].OccurredAt
+				events = append(events, event)
+			}
+			if tt.wantEvents == nil {
+				tt.wantEvents = []AuditEvent{}
+			}
+			if !reflect.DeepEqual(events, tt.wantEvents) {
+				t.Errorf("events = %+v, want %+v", events, tt.wantEvents)
+			}
+		})
+	}
+}
+
+func TestAuditPlugin_OutboxCascadeAndInPlace(t *testing.T) {
+
+	type LedgerEntry struct {
+		AuditableModel
+		LedgerID uint
+		Amount   int
+	}
+	type Ledger struct {
+		AuditableModel
+		Name    string
+		Views   int           `audit:"-"`
+		Entries []LedgerEntry `audit:"cascade"`
+	}
+
+	tests := []struct {
+		name       string
+		pattern    string
+		change     func(db *gorm.DB, ledger *Ledger) error
+		wantErr    error
+		wantEvents []AuditEvent
+	}{
+		{
+			name: "Success, event of update in place",
+			change: func(db *gorm.DB, ledger *Ledger) error {
+				ledger.Views = 5
+				return db.Updates(ledger).Error
+			},
+			wantEvents: []AuditEvent{
+				{Entity: "ledgers", Operation: EventUpdate, EntityID: 1, OldVersionID: 1, NewVersionID: 1, Actor: "admin",
+					Diff: map[string]FieldChange{"views": {Old: float64(0), New: float64(5)}}},
+			},
+		},
+		{
+			name: "Success, events of cascaded deletes",
+			change: func(db *gorm.DB, ledger *Ledger) error {
+				return db.WithContext(WithActor(context.Background(), "bob")).Delete(ledger).Error
+			},
+			wantEvents: []AuditEvent{
+				{Entity: "ledger_entries", Operation: EventDelete, EntityID: 1, OldVersionID: 1, Actor: "bob",
+					Diff: map[string]FieldChange{"ledger_id": {Old: float64(1)}, "amount": {Old: float64(10)}}},
+				{Entity: "ledgers", Operation: EventDelete, EntityID: 1, OldVersionID: 1, Actor: "bob",
+					Diff: map[string]FieldChange{"name": {Old: "main"}, "views": {Old: float64(0)}}},
+			},
+		},
+		{
+			name:    "Fail recording event of update in place",
+			pattern: "^INSERT INTO `audit_outbox_events`",
+			change: func(db *gorm.DB, ledger *Ledger) error {
+				ledger.Views = 5
+				return db.Updates(ledger).Error
+			},
+			wantErr:    errFailingStatement,
+			wantEvents: []AuditEvent{},
+		},
+		{
+			name:    "Fail recording events of cascaded deletes",
+			pattern: "^INSERT INTO `audit_outbox_events`",
+			change: func(db *gorm.DB, ledger *Ledger) error {
+				return db.Delete(ledger).Error
+			},
+			wantErr:    errFailingStatement,
+			wantEvents: []AuditEvent{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, err := createDatabaseWithPlugin(MegaGormAuditPlugin{Outbox: true})
+			if err != nil {
+				t.Errorf("createDatabase() error = %v", err)
+				return
+			}
+
+			err = db.AutoMigrate(Ledger{}, LedgerEntry{}, OutboxEvent{})
+			if err != nil {
+				t.Errorf("AutoMigrate() error = %v", err)
+				return
+			}
+
+			ledger := &Ledger{AuditableModel: AuditableModel{LastChangedUser: "admin"}, Name: "main",
+				Entries: []LedgerEntry{{AuditableModel: AuditableModel{LastChangedUser: "admin"}, Amount: 10}}}
+			if err := db.Create(ledger).Error; err != nil {
+				t.Errorf("Create() error = %v", err)
+				return
+			}
+			var created int64
+			db.Model(&OutboxEvent{}).Count(&created)
+
+			if tt.pattern != "" {
+				failOn(db, tt.pattern)
+			}
+			err = tt.change(db, ledger)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("change() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+
+			var outboxEvents []OutboxEvent
+			db.Where("id > ?", created).Order("id").Find(&outboxEvents)
+			events := []AuditEvent{}
+			for _, outboxEvent := range outboxEvents {
+				var event AuditEvent
+				if err := json.Unmarshal([]byte(outboxEvent.Payload), &event); err != nil {
+					t.Errorf("Unmarshal() error = %v", err)
+					return
+				}
+				event.OccurredAt = time.Time{}
+				events = append(events, event)
+			}
+			if !reflect.DeepEqual(events, tt.wantEvents) {
+				t.Errorf("events = %+v, want %+v", events, tt.wantEvents)
+			}
+		})
+	}
+}
+
+func TestAuditPlugin_OutboxDiff(t *testing.T) {
+
+	type Voucher struct {
+		AuditableModel
+		Code      string `gorm:"uniqueIndex:idx_vouchers_code,where:deleted_at = 0"`
+		Pin       string `audit:"redact"`
+		ExpiresOn sql.NullString
+		Value     float64
+	}
+
+	tests := []struct {
+		name      string
+		change    func(db *gorm.DB, voucher *Voucher) error
+		wantErr   bool
+		wantDiffs []map[string]FieldChange
+	}{
+		{
+			name: "Success, sensitive field listed without values",
+			change: func(db *gorm.DB, voucher *Voucher) error {
+				voucher.Pin = "4321"
+				return db.Updates(voucher).Error
+			},
+			wantDiffs: []map[string]FieldChange{{"pin": {}}},
+		},
+		{
+			name: "Success, value of valuer field",
+			change: func(db *gorm.DB, voucher *Voucher) error {
+				voucher.ExpiresOn = sql.NullString{String: "2030-12-31", Valid: true}
+				return db.Updates(voucher).Error
+			},
+			wantDiffs: []map[string]FieldChange{{"expires_on": {New: "2030-12-31"}}},
+		},
+		{
+			name: "Success, update without changes",
+			change: func(db *gorm.DB, voucher *Voucher) error {
+				return db.Updates(voucher).Error
+			},
+			wantDiffs: []map[string]FieldChange{nil},
+		},
+		{
+			name: "Success, no event of ignored conflict",
+			change: func(db *gorm.DB, voucher *Voucher) error {
+				return db.Clauses(clause.OnConflict{DoNothing: true}).Create(&Voucher{Code: "v1"}).Error
+			},
+			wantDiffs: []map[string]FieldChange{},
+		},
+		{
+			name: "Fail on value that can't be encoded",
+			change: func(db *gorm.DB, voucher *Voucher) error {
+				voucher.Value = math.NaN()
+				return db.Updates(voucher).Error
+			},
+			wantErr:   true,
+			wantDiffs: []map[string]FieldChange{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, err := createDatabaseWithPlugin(MegaGormAuditPlugin{Outbox: true})
+			if err != nil {
+				t.Errorf("createDatabase() error = %v", err)
+				return
+			}
+
+			err = db.AutoMigrate(Voucher{}, OutboxEvent{})
+			if err != nil {
+				t.Errorf("AutoMigrate() error = %v", err)
+				return
+			}
+
+			voucher := &Voucher{Code: "v1", Pin: "1234", Value: 10}
+			if err := db.Create(voucher).Error; err != nil {
+				t.Errorf("Create() error = %v", err)
+				return
+			}
+
+			err = tt.change(db, voucher)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("change() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+
+			var outboxEvents []OutboxEvent
+			db.Where("id > 1").Order("id").Find(&outboxEvents)
+			diffs := []map[string]FieldChange{}
+			for _, outboxEvent := range outboxEvents {
+				var event AuditEvent
+				if err := json.Unmarshal([]byte(outboxEvent.Payload), &event); err != nil {
+					t.Errorf("Unmarshal() error = %v", err)
+					return
+				}
+				diffs = append(diffs, event.Diff)
+			}
+			if !reflect.DeepEqual(diffs, tt.wantDiffs) {
+				t.Errorf("diffs = %+v, want %+v", diffs, tt.wantDiffs)
+			}
+		})
+	}
+}
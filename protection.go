@@ -0,0 +1,210 @@
+package MegaGormAudit
+
+import (
+	"errors"
+	"fmt"
+	"gorm.io/gorm"
+	"gorm.io/gorm/callbacks"
+	"gorm.io/gorm/clause"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// ProtectionMode defines what happens to updates of auditable tables that would skip versioning.
+type ProtectionMode int
+
+const (
+	ProtectionOff     ProtectionMode = iota //updates skipping versioning are executed
+	ProtectionVersion                       //updates of auditable models matching conditions version each live row, other bypasses fail
+	ProtectionReject                        //updates skipping versioning fail with ErrAuditBypass
+)
+
+const (
+	allowBypassKey = "megagormaudit:allow_bypass"
+	skipKey        = "megagormaudit:skip"
+)
+
+var ErrAuditBypass = errors.New("megagormaudit: statement changes an auditable table without versioning")
+
+// rawWrite matches raw statements changing rows in place, capturing the table.
+var rawWrite = regexp.MustCompile("(?i)^\\s*(?:UPDATE(?:\\s+OR\\s+\\w+)?|DELETE\\s+FROM)\\s+((?:[`\"\\[]?\\w+[`\"\\]]?\\.)?[`\"\\[]?\\w+[`\"\\]]?)")
+
+// auditableTables holds, for each connection pool, the tables of the auditable models seen by the plugin.
+var auditableTables sync.Map
+
+// AllowBypass is a scope allowing the statement to change auditable tables in place, for maintenance jobs.
+//
+//	db.Scopes(AllowBypass).Exec("UPDATE players SET name = ?", "fixed")
+func AllowBypass(db *gorm.DB) *gorm.DB {
+	return db.Set(allowBypassKey, true)
+}
+
+// Skip is a scope updating auditable models in place, without versioning, logging a warning with the actor.
+// It's the same as db.Set("megagormaudit:skip", true).
+//
+//	db.Scopes(Skip).Model(&account).Update("balance", 0)
+func Skip(db *gorm.DB) *gorm.DB {
+	return db.Set(skipKey, true)
+}
+
+func skipped(db *gorm.DB) bool {
+	skip, _ := db.Get(skipKey)
+	return skip == true
+}
+
+func bypassAllowed(db *gorm.DB) bool {
+	allowed, _ := db.Get(allowBypassKey)
+	return allowed == true
+}
+
+func registerAuditableTable(db *gorm.DB, table string) {
+	tables, _ := auditableTables.LoadOrStore(db.Config.ConnPool, &sync.Map{})
+	tables.(*sync.Map).Store(table, true)
+}
+
+func isAuditableTable(db *gorm.DB, table string) bool {
+	if tables, ok := auditableTables.Load(db.Config.ConnPool); ok {
+		_, auditable := tables.(*sync.Map).Load(table)
+		return auditable
+	}
+	return false
+}
+
+// registerStatementTable registers the table of the statement when its model is auditable.
+func registerStatementTable(db *gorm.DB) {
+	if db.Statement.Schema != nil && isAuditableSchema(db.Statement.Schema) {
+		registerAuditableTable(db, db.Statement.Table)
+	}
+}
+
+// guardRaw applies the protection mode to raw SQL updating or deleting rows of auditable tables.
+func (a MegaGormAuditPlugin) guardRaw(db *gorm.DB) {
+	registerStatementTable(db)
+	if a.Protection == ProtectionOff || db.Error != nil || db.Statement.SQL.Len() == 0 || bypassAllowed(db) {
+		return
+	}
+
+	match := rawWrite.FindStringSubmatch(db.Statement.SQL.String())
+	if match == nil {
+		return
+	}
+
+	table := match[1]
+	if i := strings.LastIndex(table, "."); i >= 0 {
+		table = table[i+1:]
+	}
+	table = strings.Trim(table, "`\"[]")
+	if isAuditableTable(db, table) {
+		db.AddError(fmt.Errorf("%w: raw SQL on %s", ErrAuditBypass, table))
+	}
+}
+
+// guardUpdate applies the protection mode to updates that don't identify an auditable model,
+// or executes the update in place when the bypass is allowed or the update is skipped.
+// It reports whether the update was handled.
+func (a MegaGormAuditPlugin) guardUpdate(db *gorm.DB) bool {
+	registerStatementTable(db)
+	if bypassAllowed(db) || skipped(db) {
+		if skipped(db) {
+			//the actor of the model is already in the context, set by contextActor
+			actor := actorFromContext(db.Statement.Context)
+			db.Logger.Warn(db.Statement.Context, "megagormaudit: auditing of %s update skipped by %q", db.Statement.Table, actor)
+		}
+		callbacks.Update(&callbacks.Config{})(db)
+		return true
+	}
+
+	if _, detach := db.Statement.Settings.Load(nativeDetachKey); detach {
+		db.AddError(versionMatching(db))
+		return true
+	}
+
+	auditable := db.Statement.Schema != nil && isAuditableSchema(db.Statement.Schema)
+	model := reflect.Indirect(reflect.ValueOf(db.Statement.Model))
+	if a.Protection == ProtectionOff || (auditable && model.Kind() == reflect.Struct && auditableModelOf(model.Interface()).ID != 0) ||
+		!isAuditableTable(db, db.Statement.Table) {
+		return false
+	}
+
+	if a.Protection == ProtectionReject || !auditable {
+		db.AddError(fmt.Errorf("%w: update of %s without a model", ErrAuditBypass, db.Statement.Table))
+		return true
+	}
+
+	if err := versionMatching(db); err != nil {
+		db.AddError(err)
+	}
+	return true
+}
+
+// versionMatching creates a new version of each live row matching the conditions of the update.
+func versionMatching(db *gorm.DB) error {
+	where, ok := db.Statement.Clauses["WHERE"]
+	if !ok && !db.AllowGlobalUpdate {
+		return gorm.ErrMissingWhereClause
+	}
+
+	rows := reflect.New(reflect.SliceOf(db.Statement.Schema.ModelType))
+	query := db.Session(&gorm.Session{NewDB: true})
+	if ok {
+		query = query.Clauses(where.Expression)
+	}
+	if err := query.Find(rows.Interface()).Error; err != nil {
+		return err
+	}
+
+	for i := 0; i < rows.Elem().Len(); i++ {
+		row := rows.Elem().Index(i)
+		if err := applyDest(db.Statement, row); err != nil {
+			return err
+		}
+
+		result := db.Session(&gorm.Session{NewDB: true}).Updates(row.Addr().Interface())
+		if result.Error != nil {
+			return result.Error
+		}
+		db.RowsAffected += result.RowsAffected
+	}
+	return nil
+}
+
+// applyDest sets on target the values assigned by the update, when they aren't given by the model itself,
+// as in Update, Updates with a map and UpdateColumns. Values of other types can't be versioned.
+func applyDest(stmt *gorm.Statement, target reflect.Value) error {
+	if stmt.Dest == stmt.Model {
+		return nil
+	}
+
+	if values, ok := stmt.Dest.(map[string]interface{}); ok {
+		for name, value := range values {
+			field := stmt.Schema.LookUpField(name)
+			if field == nil || field.PrimaryKey {
+				continue
+			}
+			if _, expression := value.(clause.Expression); expression {
+				return fmt.Errorf("%w: expression assigned to %s can't be versioned", ErrAuditBypass, name)
+			}
+			if err := field.Set(stmt.Context, target, value); err != nil {
+				return fmt.Errorf("%w: value assigned to %s can't be versioned: %w", ErrAuditBypass, name, err)
+			}
+		}
+		return nil
+	}
+
+	dest := reflect.Indirect(reflect.ValueOf(stmt.Dest))
+	if dest.Kind() != reflect.Struct || dest.Type() != stmt.Schema.ModelType {
+		return fmt.Errorf("%w: update of %s with %T can't be versioned", ErrAuditBypass, stmt.Table, stmt.Dest)
+	}
+
+	selectColumns, restricted := stmt.SelectAndOmitColumns(false, true)
+	for _, field := range stmt.Schema.Fields {
+		_, zero := field.ValueOf(stmt.Context, dest)
+		if field.DBName == "" || field.PrimaryKey || (restricted && !selectColumns[field.DBName]) || (!restricted && zero) {
+			continue
+		}
+		field.ReflectValueOf(stmt.Context, target).Set(field.ReflectValueOf(stmt.Context, dest))
+	}
+	return nil
+}
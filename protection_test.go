@@ -0,0 +1,367 @@
+package MegaGormAudit
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+	"log"
+	"strings"
+	"testing"
+)
+
+type Account struct {
+	AuditableModel
+	Owner   string
+	Balance int
+}
+
+func TestAuditPlugin_Protection(t *testing.T) {
+
+	versioned := func(balance int) func(db *gorm.DB) bool {
+		return func(db *gorm.DB) bool {
+			var accounts []Account
+			db.Unscoped().Order("id").Find(&accounts)
+			return len(accounts) == 2 && accounts[0].Balance == 10 && accounts[0].DeletedAt > 0 &&
+				accounts[1].Balance == balance && *accounts[1].AuditParentID == 1 && accounts[1].DeletedAt == 0
+		}
+	}
+	inPlace := func(balance int) func(db *gorm.DB) bool {
+		return func(db *gorm.DB) bool {
+			var accounts []Account
+			db.Unscoped().Find(&accounts)
+			return len(accounts) == 1 && accounts[0].Balance == balance
+		}
+	}
+
+	type balanceUpdate struct {
+		Balance int
+	}
+
+	tests := []struct {
+		name        string
+		protection  ProtectionMode
+		pattern     string
+		change      func(db *gorm.DB, account *Account) error
+		wantErr     error
+		successTest func(db *gorm.DB) bool
+	}{
+		{
+			name: "Success, update column of model is versioned",
+			change: func(db *gorm.DB, account *Account) error {
+				return db.Model(account).UpdateColumn("balance", 20).Error
+			},
+			successTest: versioned(20),
+		},
+		{
+			name: "Success, updates with map are versioned",
+			change: func(db *gorm.DB, account *Account) error {
+				return db.Model(account).Updates(map[string]interface{}{"balance": 20}).Error
+			},
+			successTest: versioned(20),
+		},
+		{
+			name: "Success, updates with other struct are versioned",
+			change: func(db *gorm.DB, account *Account) error {
+				return db.Model(account).Updates(Account{Balance: 20}).Error
+			},
+			successTest: func(db *gorm.DB) bool {
+				var account Account
+				db.First(&account)
+				return versioned(20)(db) && account.Owner == "alice"
+			},
+		},
+		{
+			name: "Success, table update without protection",
+			change: func(db *gorm.DB, account *Account) error {
+				return db.Table("accounts").Where("owner = ?", "alice").Update("balance", 20).Error
+			},
+			successTest: inPlace(20),
+		},
+		{
+			name: "Success, raw update without protection",
+			change: func(db *gorm.DB, account *Account) error {
+				return db.Exec("UPDATE accounts SET balance = ?", 20).Error
+			},
+			successTest: inPlace(20),
+		},
+		{
+			name:       "Success, update matching conditions is versioned",
+			protection: ProtectionVersion,
+			change: func(db *gorm.DB, account *Account) error {
+				return db.Model(&Account{}).Where("owner = ?", "alice").Update("balance", 20).Error
+			},
+			successTest: versioned(20),
+		},
+		{
+			name:       "Success, update of model with protection",
+			protection: ProtectionReject,
+			change: func(db *gorm.DB, account *Account) error {
+				return db.Model(account).UpdateColumns(Account{Balance: 20}).Error
+			},
+			successTest: versioned(20),
+		},
+		{
+			name:       "Success, allowed bypass",
+			protection: ProtectionReject,
+			change: func(db *gorm.DB, account *Account) error {
+				err := db.Scopes(AllowBypass).Exec("UPDATE `accounts` SET balance = ?", 15).Error
+				if err != nil {
+					return err
+				}
+				return db.Scopes(AllowBypass).Model(&Account{}).Where("id = ?", 1).Update("balance", 20).Error
+			},
+			successTest: inPlace(20),
+		},
+		{
+			name:       "Success, raw query with protection",
+			protection: ProtectionReject,
+			change: func(db *gorm.DB, account *Account) error {
+				var balance int
+				return db.Raw("SELECT balance FROM accounts").Scan(&balance).Error
+			},
+			successTest: inPlace(10),
+		},
+		{
+			name:       "Reject update matching conditions",
+			protection: ProtectionReject,
+			change: func(db *gorm.DB, account *Account) error {
+				return db.Model(&Account{}).Where("owner = ?", "alice").Update("balance", 20).Error
+			},
+			wantErr:     ErrAuditBypass,
+			successTest: inPlace(10),
+		},
+		{
+			name:       "Reject table update",
+			protection: ProtectionVersion,
+			change: func(db *gorm.DB, account *Account) error {
+				return db.Table("accounts").Where("owner = ?", "alice").Update("balance", 20).Error
+			},
+			wantErr:     ErrAuditBypass,
+			successTest: inPlace(10),
+		},
+		{
+			name:       "Reject raw update",
+			protection: ProtectionVersion,
+			change: func(db *gorm.DB, account *Account) error {
+				return db.Exec(`UPDATE "accounts" SET balance = ?`, 20).Error
+			},
+			wantErr:     ErrAuditBypass,
+			successTest: inPlace(10),
+		},
+		{
+			name:       "Reject raw delete",
+			protection: ProtectionReject,
+			change: func(db *gorm.DB, account *Account) error {
+				var ids []uint
+				return db.Raw("DELETE FROM main.accounts RETURNING id").Scan(&ids).Error
+			},
+			wantErr:     ErrAuditBypass,
+			successTest: inPlace(10),
+		},
+		{
+			name:       "Reject raw update with skip",
+			protection: ProtectionReject,
+			change: func(db *gorm.DB, account *Account) error {
+				return db.Scopes(Skip).Exec("UPDATE accounts SET balance = 0").Error
+			},
+			wantErr:     ErrAuditBypass,
+			successTest: inPlace(10),
+		},
+		{
+			name: "Reject expression that can't be versioned",
+			change: func(db *gorm.DB, account *Account) error {
+				return db.Model(account).UpdateColumn("balance", gorm.Expr("balance + ?", 10)).Error
+			},
+			wantErr:     ErrAuditBypass,
+			successTest: inPlace(10),
+		},
+		{
+			name:       "Success, update matching conditions keeps primary key",
+			protection: ProtectionVersion,
+			change: func(db *gorm.DB, account *Account) error {
+				return db.Model(&Account{}).Where("owner = ?", "alice").Updates(map[string]interface{}{"id": 9, "balance": 20}).Error
+			},
+			successTest: versioned(20),
+		},
+		{
+			name:       "Reject value that can't be versioned",
+			protection: ProtectionVersion,
+			change: func(db *gorm.DB, account *Account) error {
+				return db.Model(&Account{}).Where("owner = ?", "alice").Updates(map[string]interface{}{"balance": struct{}{}}).Error
+			},
+			wantErr:     ErrAuditBypass,
+			successTest: inPlace(10),
+		},
+		{
+			name: "Reject updates with struct of another type",
+			change: func(db *gorm.DB, account *Account) error {
+				return db.Model(account).Updates(&balanceUpdate{Balance: 20}).Error
+			},
+			wantErr:     ErrAuditBypass,
+			successTest: inPlace(10),
+		},
+		{
+			name:       "Fail on update without conditions",
+			protection: ProtectionVersion,
+			change: func(db *gorm.DB, account *Account) error {
+				return db.Model(&Account{}).Update("balance", 20).Error
+			},
+			wantErr:     gorm.ErrMissingWhereClause,
+			successTest: inPlace(10),
+		},
+		{
+			name:       "Fail loading rows matching conditions",
+			protection: ProtectionVersion,
+			pattern:    "^SELECT \\* FROM `accounts` WHERE owner",
+			change: func(db *gorm.DB, account *Account) error {
+				return db.Model(&Account{}).Where("owner = ?", "alice").Update("balance", 20).Error
+			},
+			wantErr:     errFailingStatement,
+			successTest: inPlace(10),
+		},
+		{
+			name:       "Fail versioning rows matching conditions",
+			protection: ProtectionVersion,
+			pattern:    "^UPDATE `accounts`",
+			change: func(db *gorm.DB, account *Account) error {
+				return db.Model(&Account{}).Where("owner = ?", "alice").Update("balance", 20).Error
+			},
+			wantErr:     errFailingStatement,
+			successTest: inPlace(10),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, err := createDatabaseWithPlugin(MegaGormAuditPlugin{Protection: tt.protection})
+			if err != nil {
+				t.Errorf("createDatabase() error = %v", err)
+				return
+			}
+
+			err = db.AutoMigrate(Account{})
+			if err != nil {
+				t.Errorf("AutoMigrate() error = %v", err)
+				return
+			}
+
+			account := &Account{Owner: "alice", Balance: 10}
+			err = db.Create(account).Error
+			if err != nil {
+				t.Errorf("Create() error = %v", err)
+				return
+			}
+
+			if tt.pattern != "" {
+				failOn(db, tt.pattern)
+			}
+			err = tt.change(db, account)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("change() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+
+			if !tt.successTest(db) {
+				t.Errorf("successTest() = false, want true")
+			}
+		})
+	}
+}
+
+func TestAuditPlugin_ProtectionModels(t *testing.T) {
+
+	tests := []struct {
+		name    string
+		models  []interface{}
+		wantErr error
+	}{
+		{
+			name:    "Reject raw update of registered model",
+			models:  []interface{}{Account{}},
+			wantErr: ErrAuditBypass,
+		},
+		{
+			name: "Success, raw update without auditable models",
+		},
+		{
+			name:    "Fail on model that isn't auditable",
+			models:  []interface{}{Role{}},
+			wantErr: ErrNotAuditable,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, err := createDatabaseWithPlugin(MegaGormAuditPlugin{Protection: ProtectionReject, Models: tt.models})
+			if err == nil {
+				err = db.Exec("CREATE TABLE accounts (balance int)").Error
+			}
+			if err == nil {
+				err = db.Exec("UPDATE accounts SET balance = 0").Error
+			}
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestAuditPlugin_Skip(t *testing.T) {
+
+	tests := []struct {
+		name    string
+		update  func(db *gorm.DB, account *Account) error
+		wantLog string
+	}{
+		{
+			name: "Success, skip with scope",
+			update: func(db *gorm.DB, account *Account) error {
+				account.LastChangedUser = "migration"
+				return db.Scopes(Skip).Model(account).Updates(Account{Balance: 20}).Error
+			},
+			wantLog: `auditing of accounts update skipped by "migration"`,
+		},
+		{
+			name: "Success, skip with setting and context actor",
+			update: func(db *gorm.DB, account *Account) error {
+				ctx := WithActor(context.Background(), "fixer")
+				return db.WithContext(ctx).Set("megagormaudit:skip", true).Model(&Account{}).Where("owner = ?", "alice").Update("balance", 20).Error
+			},
+			wantLog: `auditing of accounts update skipped by "fixer"`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, err := createDatabase()
+			if err != nil {
+				t.Errorf("createDatabase() error = %v", err)
+				return
+			}
+			var output bytes.Buffer
+			db.Logger = logger.New(log.New(&output, "", 0), logger.Config{LogLevel: logger.Warn})
+
+			err = db.AutoMigrate(Account{})
+			if err != nil {
+				t.Errorf("AutoMigrate() error = %v", err)
+				return
+			}
+
+			account := &Account{Owner: "alice", Balance: 10}
+			db.Create(account)
+			err = tt.update(db, account)
+			if err != nil {
+				t.Errorf("update() error = %v", err)
+				return
+			}
+
+			var accounts []Account
+			db.Unscoped().Find(&accounts)
+			if len(accounts) != 1 || accounts[0].Balance != 20 {
+				t.Errorf("versions = %v, want an update in place", accounts)
+			}
+			if !strings.Contains(output.String(), tt.wantLog) {
+				t.Errorf("log = %s, want %s", output.String(), tt.wantLog)
+			}
+		})
+	}
+}
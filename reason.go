@@ -0,0 +1,53 @@
+package MegaGormAudit
+
+import (
+	"context"
+	"gorm.io/gorm"
+	"reflect"
+)
+
+type reasonKey struct{}
+
+// WithReason returns a copy of ctx carrying the reason of the changes made with it,
+// stored in the ChangeReason of the versions created or deleted.
+func WithReason(ctx context.Context, reason string) context.Context {
+	return context.WithValue(ctx, reasonKey{}, reason)
+}
+
+// reasonFromContext returns the reason set by WithReason in ctx.
+func reasonFromContext(ctx context.Context) string {
+	reason, _ := ctx.Value(reasonKey{}).(string)
+	return reason
+}
+
+// stampReason sets the ChangeReason of the auditable models created with the reason of the statement context.
+func stampReason(db *gorm.DB) {
+	if reason := reasonFromContext(db.Statement.Context); reason != "" {
+		stampAuditableField(db, "ChangeReason", reason)
+	}
+}
+
+// clearInheritedReason clears the ChangeReason model, a loaded or previously saved version, carries from previous,
+// the stored version, when ctx has no reason, so a reason is only recorded by the change it was given to.
+func clearInheritedReason(ctx context.Context, model *AuditableModel, previous interface{}) {
+	if reasonFromContext(ctx) == "" && model.ChangeReason == auditableModelOf(previous).ChangeReason {
+		model.ChangeReason = ""
+	}
+}
+
+// stampAuditableField sets the field name of the auditable models created to value.
+func stampAuditableField(db *gorm.DB, name string, value interface{}) {
+	if db.Error != nil || db.Statement.Schema == nil || !isAuditableSchema(db.Statement.Schema) {
+		return
+	}
+
+	field := db.Statement.Schema.LookUpField(name)
+	switch db.Statement.ReflectValue.Kind() {
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < db.Statement.ReflectValue.Len(); i++ {
+			db.AddError(field.Set(db.Statement.Context, reflect.Indirect(db.Statement.ReflectValue.Index(i)), value))
+		}
+	case reflect.Struct:
+		db.AddError(field.Set(db.Statement.Context, db.Statement.ReflectValue, value))
+	}
+}
@@ -0,0 +1,113 @@
+package MegaGormAudit
+
+import (
+	"context"
+	"gorm.io/gorm"
+	"testing"
+)
+
+func TestAuditPlugin_ChangeReason(t *testing.T) {
+
+	tests := []struct {
+		name        string
+		change      func(db *gorm.DB, account *Account) error
+		wantReasons []string
+	}{
+		{
+			name: "Success, reason from context on update",
+			change: func(db *gorm.DB, account *Account) error {
+				account.Balance = 20
+				return db.WithContext(WithReason(context.Background(), "ticket 1234")).Updates(account).Error
+			},
+			wantReasons: []string{"ticket 1234", "ticket 1234"},
+		},
+		{
+			name: "Success, reason from model on update",
+			change: func(db *gorm.DB, account *Account) error {
+				account.Balance = 20
+				account.ChangeReason = "ticket 1234"
+				return db.Updates(account).Error
+			},
+			wantReasons: []string{"ticket 1234", "ticket 1234"},
+		},
+		{
+			name: "Success, reason from context on delete",
+			change: func(db *gorm.DB, account *Account) error {
+				return db.WithContext(WithReason(context.Background(), "closed")).Delete(account).Error
+			},
+			wantReasons: []string{"closed"},
+		},
+		{
+			name: "Success, reason of a previous change isn't inherited on update",
+			change: func(db *gorm.DB, account *Account) error {
+				account.Balance = 20
+				if err := db.WithContext(WithReason(context.Background(), "ticket 1")).Updates(account).Error; err != nil {
+					return err
+				}
+
+				var loaded Account
+				db.Take(&loaded)
+				loaded.Balance = 30
+				return db.Updates(&loaded).Error
+			},
+			wantReasons: []string{"ticket 1", "", ""},
+		},
+		{
+			name: "Success, reason of a previous change isn't inherited on delete",
+			change: func(db *gorm.DB, account *Account) error {
+				account.Balance = 20
+				if err := db.WithContext(WithReason(context.Background(), "ticket 1")).Updates(account).Error; err != nil {
+					return err
+				}
+
+				var loaded Account
+				db.Take(&loaded)
+				return db.Delete(&loaded).Error
+			},
+			wantReasons: []string{"ticket 1", ""},
+		},
+		{
+			name: "Success, reason from context on create",
+			change: func(db *gorm.DB, account *Account) error {
+				return db.WithContext(WithReason(context.Background(), "opened")).Create(&Account{Owner: "bob"}).Error
+			},
+			wantReasons: []string{"", "opened"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, err := createDatabase()
+			if err != nil {
+				t.Errorf("createDatabase() error = %v", err)
+				return
+			}
+
+			err = db.AutoMigrate(Account{})
+			if err != nil {
+				t.Errorf("AutoMigrate() error = %v", err)
+				return
+			}
+
+			account := &Account{Owner: "alice", Balance: 10}
+			db.Create(account)
+
+			err = tt.change(db, account)
+			if err != nil {
+				t.Errorf("change() error = %v", err)
+				return
+			}
+
+			var reasons []string
+			db.Unscoped().Model(&Account{}).Order("id").Pluck("change_reason", &reasons)
+			if len(reasons) != len(tt.wantReasons) {
+				t.Errorf("reasons = %v, want %v", reasons, tt.wantReasons)
+				return
+			}
+			for i := range reasons {
+				if reasons[i] != tt.wantReasons[i] {
+					t.Errorf("reasons = %v, want %v", reasons, tt.wantReasons)
+				}
+			}
+		})
+	}
+}
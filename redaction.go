@@ -0,0 +1,78 @@
+package MegaGormAudit
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+	"reflect"
+	"strings"
+)
+
+const maskedSuffix = 4 //characters kept at the end of masked values
+
+// redaction returns the audit tag redacting field in superseded versions: "redact", "hash", "mask" or "encrypt".
+func redaction(field *schema.Field) string {
+	for _, tag := range []string{"redact", "hash", "mask", "encrypt"} {
+		if hasAuditTag(field, tag) {
+			return tag
+		}
+	}
+	return ""
+}
+
+// redactedValue returns the value of a field redacted with tag in a superseded version.
+// Strings are hashed with HMAC-SHA256 keyed with hashKey, or SHA-256 without a key, or masked,
+// keeping the last characters, other values are zeroed.
+func redactedValue(tag string, field *schema.Field, value interface{}, hashKey []byte) interface{} {
+	rv := reflect.Indirect(reflect.ValueOf(value))
+	if tag != "redact" && rv.Kind() == reflect.String {
+		if tag == "hash" {
+			if len(hashKey) == 0 {
+				sum := sha256.Sum256([]byte(rv.String()))
+				return hex.EncodeToString(sum[:])
+			}
+			mac := hmac.New(sha256.New, hashKey)
+			mac.Write([]byte(rv.String()))
+			return hex.EncodeToString(mac.Sum(nil))
+		}
+
+		runes := []rune(rv.String())
+		masked := len(runes) - maskedSuffix
+		if masked < 0 {
+			masked = len(runes)
+		}
+		return strings.Repeat("*", masked) + string(runes[masked:])
+	}
+	return reflect.Zero(field.FieldType).Interface()
+}
+
+// redactVersion redacts or encrypts the sensitive fields of previous, superseded as the version id.
+func (a MegaGormAuditPlugin) redactVersion(tx *gorm.DB, auditSchema *schema.Schema, previous interface{}, id uint) error {
+	rv := reflect.Indirect(reflect.ValueOf(previous))
+	values := map[string]interface{}{}
+	for _, field := range auditSchema.Fields {
+		tag := redaction(field)
+		if tag == "" || field.DBName == "" {
+			continue
+		}
+
+		value, _ := field.ValueOf(tx.Statement.Context, rv)
+		if tag == "encrypt" {
+			encrypted, err := a.encryptValue(field, value)
+			if err != nil {
+				return err
+			}
+			values[field.DBName] = encrypted
+		} else {
+			values[field.DBName] = redactedValue(tag, field, value, a.HashKey)
+		}
+	}
+	if len(values) == 0 {
+		return nil
+	}
+
+	session := tx.Session(&gorm.Session{NewDB: true, SkipHooks: true})
+	return AllowBypass(session).Table(auditSchema.Table).Where("id = ?", id).UpdateColumns(values).Error
+}
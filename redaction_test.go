@@ -0,0 +1,172 @@
+package MegaGormAudit
+
+import (
+	"context"
+	"errors"
+	"gorm.io/gorm"
+	"testing"
+)
+
+type Customer struct {
+	AuditableModel
+	Name     string
+	Password string  `audit:"redact"`
+	Token    string  `audit:"hash"`
+	Document string  `audit:"mask"`
+	Nickname *string `audit:"redact"`
+	Pin      string  `audit:"mask"`
+}
+
+func TestAuditPlugin_Redaction(t *testing.T) {
+
+	tests := []struct {
+		name        string
+		plugin      MegaGormAuditPlugin
+		change      func(db *gorm.DB, customer *Customer) error
+		successTest func(versions []Customer) bool
+	}{
+		{
+			name: "Success, superseded version redacted",
+			change: func(db *gorm.DB, customer *Customer) error {
+				customer.Name = "updated"
+				return db.Updates(customer).Error
+			},
+			successTest: func(versions []Customer) bool {
+				old, live := versions[0], versions[1]
+				return len(versions) == 2 && old.Name == "customer" && old.Password == "" &&
+					old.Token == "3c469e9d6c5875d37a43f353d4f88e61fcf812c66eee3457465a40b0da4153e0" &&
+					old.Document == "******6789" && old.Nickname == nil && old.Pin == "***" &&
+					live.Password == "secret" && live.Token == "token" && live.Document == "12345-6789" && *live.Nickname == "nick" && live.Pin == "123"
+			},
+		},
+		{
+			name:   "Success, superseded version hashed with key",
+			plugin: MegaGormAuditPlugin{HashKey: []byte("secret key")},
+			change: func(db *gorm.DB, customer *Customer) error {
+				customer.Name = "updated"
+				return db.Updates(customer).Error
+			},
+			successTest: func(versions []Customer) bool {
+				return len(versions) == 2 && versions[0].Token == "963d1fe2af79a1d3c7949bb8b85c11855e555236b3f3fc2e9fc0c6fbaf2dae40" &&
+					versions[1].Token == "token"
+			},
+		},
+		{
+			name: "Success, deleted version redacted",
+			change: func(db *gorm.DB, customer *Customer) error {
+				return db.Delete(customer).Error
+			},
+			successTest: func(versions []Customer) bool {
+				deleted := versions[0]
+				return len(versions) == 1 && deleted.DeletedAt > 0 && deleted.Name == "customer" && deleted.Password == "" &&
+					deleted.Token == "3c469e9d6c5875d37a43f353d4f88e61fcf812c66eee3457465a40b0da4153e0" &&
+					deleted.Document == "******6789" && deleted.Nickname == nil && deleted.Pin == "***"
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, err := createDatabaseWithPlugin(tt.plugin)
+			if err != nil {
+				t.Errorf("createDatabase() error = %v", err)
+				return
+			}
+
+			err = db.AutoMigrate(Customer{})
+			if err != nil {
+				t.Errorf("AutoMigrate() error = %v", err)
+				return
+			}
+
+			nickname := "nick"
+			customer := &Customer{Name: "customer", Password: "secret", Token: "token", Document: "12345-6789", Nickname: &nickname, Pin: "123"}
+			db.Create(customer)
+
+			err = tt.change(db, customer)
+			if err != nil {
+				t.Errorf("change() error = %v", err)
+				return
+			}
+
+			var versions []Customer
+			db.Unscoped().Order("id").Find(&versions)
+			if !tt.successTest(versions) {
+				t.Errorf("versions = %+v, want redacted history", versions)
+			}
+
+			tampered, err := Verify(context.Background(), db, &Customer{})
+			if err != nil || len(tampered) > 0 {
+				t.Errorf("Verify() = %v, %v, want no tampered versions", tampered, err)
+			}
+		})
+	}
+}
+
+func TestAuditPlugin_CascadeRedaction(t *testing.T) {
+
+	type WalletCard struct {
+		AuditableModel
+		WalletID uint
+		Number   string `audit:"mask"`
+	}
+	type Wallet struct {
+		AuditableModel
+		Owner string
+		Cards []WalletCard `audit:"cascade"`
+	}
+
+	tests := []struct {
+		name        string
+		pattern     string
+		wantErr     error
+		successTest func(cards []WalletCard) bool
+	}{
+		{
+			name: "Success, cascaded deletion redacted",
+			successTest: func(cards []WalletCard) bool {
+				return len(cards) == 1 && cards[0].DeletedAt > 0 && cards[0].Number == "************1111"
+			},
+		},
+		{
+			name:    "Fail redacting cascaded deletion",
+			pattern: "^UPDATE `wallet_cards` SET `number`",
+			wantErr: errFailingStatement,
+			successTest: func(cards []WalletCard) bool {
+				return len(cards) == 1 && cards[0].DeletedAt == 0 && cards[0].Number == "4111111111111111"
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, err := createDatabase()
+			if err != nil {
+				t.Errorf("createDatabase() error = %v", err)
+				return
+			}
+
+			err = db.AutoMigrate(Wallet{}, WalletCard{})
+			if err != nil {
+				t.Errorf("AutoMigrate() error = %v", err)
+				return
+			}
+
+			wallet := &Wallet{Owner: "owner", Cards: []WalletCard{{Number: "4111111111111111"}}}
+			db.Create(wallet)
+
+			if tt.pattern != "" {
+				failOn(db, tt.pattern)
+			}
+			err = db.Delete(wallet).Error
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("Delete() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+
+			var cards []WalletCard
+			db.Unscoped().Find(&cards)
+			if !tt.successTest(cards) {
+				t.Errorf("cards = %+v, want redacted history", cards)
+			}
+		})
+	}
+}
@@ -0,0 +1,120 @@
+package MegaGormAudit
+
+import (
+	"context"
+	"encoding/json"
+	"gorm.io/gorm"
+	"log/slog"
+	"time"
+)
+
+const (
+	defaultRelayBatchSize   = 100
+	defaultRelayInterval    = time.Second
+	defaultRelayMaxAttempts = 10
+)
+
+// Publisher delivers audit events to downstream systems. Events can be delivered more than once,
+// so publishers and their consumers should be idempotent on AuditEvent.ID.
+type Publisher interface {
+	Publish(ctx context.Context, event AuditEvent) error
+}
+
+// Relay delivers the events recorded in OutboxEvent to a Publisher, in the order they were recorded.
+// An event is marked as delivered only after it is published, giving at least once delivery.
+type Relay struct {
+	DB          *gorm.DB
+	Publisher   Publisher
+	BatchSize   int           //events delivered by each RelayOnce, 100 when zero
+	Interval    time.Duration //time between RelayOnce calls of Run, one second when zero
+	MaxAttempts int           //failed attempts after which an event is parked, 10 when zero
+	Logger      *slog.Logger  //logs the errors of Run and the parked events, slog.Default() when nil
+}
+
+// RelayOnce publishes the pending events, up to BatchSize, and returns how many were delivered.
+// It stops at the first event the Publisher fails to publish, recording the error, so it is retried first.
+// An event that fails MaxAttempts times is parked instead, setting ParkedAt, and the next events are delivered.
+func (r *Relay) RelayOnce(ctx context.Context) (int, error) {
+	db := r.DB.WithContext(ctx)
+	batchSize := r.BatchSize
+	if batchSize <= 0 {
+		batchSize = defaultRelayBatchSize
+	}
+
+	maxAttempts := r.MaxAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = defaultRelayMaxAttempts
+	}
+
+	var events []OutboxEvent
+	if err := db.Where("delivered_at IS NULL AND parked_at IS NULL").Order("id").Limit(batchSize).Find(&events).Error; err != nil {
+		return 0, err
+	}
+
+	delivered := 0
+	for _, outboxEvent := range events {
+		var event AuditEvent
+		err := json.Unmarshal([]byte(outboxEvent.Payload), &event)
+		if err == nil {
+			event.ID = outboxEvent.ID
+			err = r.Publisher.Publish(ctx, event)
+		}
+
+		if err != nil {
+			values := map[string]interface{}{"attempts": gorm.Expr("attempts + 1"), "last_error": err.Error()}
+			parked := outboxEvent.Attempts+1 >= maxAttempts
+			if parked {
+				values["parked_at"] = db.NowFunc()
+			}
+			if update := db.Model(&outboxEvent).Updates(values); update.Error != nil {
+				return delivered, update.Error
+			}
+			if !parked {
+				return delivered, err
+			}
+
+			r.logger().LogAttrs(ctx, slog.LevelWarn, "outbox event parked",
+				slog.Uint64("event_id", uint64(outboxEvent.ID)), slog.Int("attempts", outboxEvent.Attempts+1), slog.String("error", err.Error()))
+			continue
+		}
+
+		if err := db.Model(&outboxEvent).Update("delivered_at", db.NowFunc()).Error; err != nil {
+			return delivered, err
+		}
+		delivered++
+	}
+	return delivered, nil
+}
+
+// Run calls RelayOnce every Interval until ctx is done, returning its error.
+// The errors of RelayOnce are logged and the events that fail to be published are retried in the next call.
+func (r *Relay) Run(ctx context.Context) error {
+	interval := r.Interval
+	if interval <= 0 {
+		interval = defaultRelayInterval
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if _, err := r.RelayOnce(ctx); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			r.logger().LogAttrs(ctx, slog.LevelError, "outbox relay failed", slog.String("error", err.Error()))
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+func (r *Relay) logger() *slog.Logger {
+	if r.Logger == nil {
+		return slog.Default()
+	}
+	return r.Logger
+}
@@ -0,0 +1,242 @@
+package MegaGormAudit
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+var errUnavailable = errors.New("publisher unavailable")
+
+type recordingPublisher struct {
+	failures int
+	events   []AuditEvent
+}
+
+func (p *recordingPublisher) Publish(ctx context.Context, event AuditEvent) error {
+	if p.failures > 0 {
+		p.failures--
+		return errUnavailable
+	}
+	p.events = append(p.events, event)
+	return nil
+}
+
+func TestRelay_RelayOnce(t *testing.T) {
+
+	tests := []struct {
+		name          string
+		failures      int
+		batchSize     int
+		maxAttempts   int
+		wantDelivered []int
+		wantErr       []error
+		wantIDs       []uint
+		wantAttempts  int
+		wantParked    bool
+	}{
+		{
+			name:          "Success, delivers pending events in order",
+			wantDelivered: []int{3, 0},
+			wantErr:       []error{nil, nil},
+			wantIDs:       []uint{1, 2, 3},
+		},
+		{
+			name:          "Success, delivers in batches",
+			batchSize:     2,
+			wantDelivered: []int{2, 1},
+			wantErr:       []error{nil, nil},
+			wantIDs:       []uint{1, 2, 3},
+		},
+		{
+			name:          "Fail on publisher error, retrying the event",
+			failures:      1,
+			wantDelivered: []int{0, 3},
+			wantErr:       []error{errUnavailable, nil},
+			wantIDs:       []uint{1, 2, 3},
+			wantAttempts:  1,
+		},
+		{
+			name:          "Success, parks the event after max attempts",
+			failures:      2,
+			maxAttempts:   2,
+			wantDelivered: []int{0, 2, 0},
+			wantErr:       []error{errUnavailable, nil, nil},
+			wantIDs:       []uint{2, 3},
+			wantAttempts:  2,
+			wantParked:    true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, err := createDatabaseWithPlugin(MegaGormAuditPlugin{Outbox: true})
+			if err != nil {
+				t.Errorf("createDatabase() error = %v", err)
+				return
+			}
+
+			err = db.AutoMigrate(Account{}, OutboxEvent{})
+			if err != nil {
+				t.Errorf("AutoMigrate() error = %v", err)
+				return
+			}
+
+			account := &Account{Owner: "alice", Balance: 10}
+			db.Create(account)
+			account.Balance = 20
+			db.Updates(account)
+			db.Delete(account)
+
+			publisher := &recordingPublisher{failures: tt.failures}
+			relay := &Relay{DB: db, Publisher: publisher, BatchSize: tt.batchSize, MaxAttempts: tt.maxAttempts, Logger: slog.New(slog.NewTextHandler(io.Discard, nil))}
+			for i := range tt.wantDelivered {
+				delivered, err := relay.RelayOnce(context.Background())
+				if delivered != tt.wantDelivered[i] || !errors.Is(err, tt.wantErr[i]) {
+					t.Errorf("RelayOnce() = %v, %v, want %v, %v", delivered, err, tt.wantDelivered[i], tt.wantErr[i])
+					return
+				}
+			}
+
+			ids := make([]uint, 0, len(publisher.events))
+			for _, event := range publisher.events {
+				ids = append(ids, event.ID)
+			}
+			if !reflect.DeepEqual(ids, tt.wantIDs) {
+				t.Errorf("published IDs = %v, want %v", ids, tt.wantIDs)
+			}
+
+			var first OutboxEvent
+			db.First(&first)
+			if (first.DeliveredAt == nil) != tt.wantParked || (first.ParkedAt != nil) != tt.wantParked || first.Attempts != tt.wantAttempts {
+				t.Errorf("OutboxEvent = %+v, want delivered or parked after %v attempts", first, tt.wantAttempts)
+			}
+		})
+	}
+}
+
+func TestRelay_Run(t *testing.T) {
+	db, err := createDatabaseWithPlugin(MegaGormAuditPlugin{Outbox: true})
+	if err != nil {
+		t.Errorf("createDatabase() error = %v", err)
+		return
+	}
+
+	err = db.AutoMigrate(Account{}, OutboxEvent{})
+	if err != nil {
+		t.Errorf("AutoMigrate() error = %v", err)
+		return
+	}
+	db.Create(&Account{Owner: "alice"})
+
+	var logs bytes.Buffer
+	publisher := &recordingPublisher{failures: 1}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err = (&Relay{DB: db, Publisher: publisher, Interval: time.Millisecond, Logger: slog.New(slog.NewTextHandler(&logs, nil))}).Run(ctx)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Run() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if len(publisher.events) != 1 {
+		t.Errorf("published events = %v, want 1", len(publisher.events))
+	}
+	if !strings.Contains(logs.String(), "outbox relay failed") || !strings.Contains(logs.String(), errUnavailable.Error()) {
+		t.Errorf("logs = %q, want the relay error", logs.String())
+	}
+}
+
+func TestRelay_RelayOnceFailOnDatabase(t *testing.T) {
+
+	tests := []struct {
+		name          string
+		pattern       string
+		failures      int
+		wantPublished int
+	}{
+		{
+			name:    "Fail loading pending events",
+			pattern: "^SELECT \\* FROM `audit_outbox_events` WHERE",
+		},
+		{
+			name:     "Fail recording the publisher error",
+			pattern:  "^UPDATE `audit_outbox_events` SET `attempts`",
+			failures: 1,
+		},
+		{
+			name:          "Fail marking the event as delivered",
+			pattern:       "^UPDATE `audit_outbox_events` SET `delivered_at`",
+			wantPublished: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, err := createDatabaseWithPlugin(MegaGormAuditPlugin{Outbox: true})
+			if err != nil {
+				t.Errorf("createDatabase() error = %v", err)
+				return
+			}
+
+			err = db.AutoMigrate(Account{}, OutboxEvent{})
+			if err != nil {
+				t.Errorf("AutoMigrate() error = %v", err)
+				return
+			}
+			db.Create(&Account{Owner: "alice"})
+
+			failOn(db, tt.pattern)
+			publisher := &recordingPublisher{failures: tt.failures}
+			delivered, err := (&Relay{DB: db, Publisher: publisher}).RelayOnce(context.Background())
+			if delivered != 0 || !errors.Is(err, errFailingStatement) {
+				t.Errorf("RelayOnce() = %v, %v, want 0, %v", delivered, err, errFailingStatement)
+				return
+			}
+			if len(publisher.events) != tt.wantPublished {
+				t.Errorf("published events = %v, want %v", len(publisher.events), tt.wantPublished)
+			}
+
+			var first OutboxEvent
+			db.First(&first)
+			if first.DeliveredAt != nil || first.Attempts != 0 {
+				t.Errorf("OutboxEvent = %+v, want pending without attempts", first)
+			}
+		})
+	}
+}
+
+func TestRelay_RunCanceled(t *testing.T) {
+	db, err := createDatabaseWithPlugin(MegaGormAuditPlugin{Outbox: true})
+	if err != nil {
+		t.Errorf("createDatabase() error = %v", err)
+		return
+	}
+
+	err = db.AutoMigrate(OutboxEvent{})
+	if err != nil {
+		t.Errorf("AutoMigrate() error = %v", err)
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = (&Relay{DB: db, Publisher: &recordingPublisher{}}).Run(ctx)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Run() error = %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestRelay_logger(t *testing.T) {
+	relay := &Relay{}
+	if relay.logger() != slog.Default() {
+		t.Errorf("logger() = %v, want slog.Default()", relay.logger())
+	}
+
+	relay.Logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	if relay.logger() != relay.Logger {
+		t.Errorf("logger() = %v, want %v", relay.logger(), relay.Logger)
+	}
+}
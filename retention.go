@@ -0,0 +1,124 @@
+package MegaGormAudit
+
+import (
+	"context"
+	"errors"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"time"
+)
+
+const defaultBatchSize = 500
+
+var ErrNoRetentionPolicy = errors.New("megagormaudit: model does not define a retention policy")
+
+// RetentionPolicy defines which superseded versions of an auditable model are kept by Purge.
+// The live version, the last version of deleted records and the original one (the audit parent) are always kept.
+type RetentionPolicy struct {
+	KeepVersions int           //number of most recent superseded versions kept for each record
+	KeepFor      time.Duration //superseded versions newer than this duration are kept
+	BatchSize    int           //number of versions removed per transaction, defaults to 500
+}
+
+// AuditRetention is implemented by auditable models that define a retention policy.
+type AuditRetention interface {
+	AuditRetentionPolicy() RetentionPolicy
+}
+
+type versionRow struct {
+	ID            uint
+	AuditParentID *uint
+	DeletedAt     int64
+}
+
+// Purge removes the superseded versions of model that are not kept by its retention policy,
+// recording the chain anchors of the versions that follow them. It returns the number of removed versions.
+func Purge(ctx context.Context, db *gorm.DB, model interface{}) (int64, error) {
+	retention, ok := model.(AuditRetention)
+	if !ok {
+		return 0, ErrNoRetentionPolicy
+	}
+	policy := retention.AuditRetentionPolicy()
+	if policy.KeepVersions <= 0 && policy.KeepFor <= 0 {
+		return 0, ErrNoRetentionPolicy
+	}
+
+	db = db.WithContext(ctx)
+	auditSchema, err := auditableSchema(db, model)
+	if err != nil {
+		return 0, err
+	}
+
+	rows, err := supersededVersions(db, auditSchema.Table)
+	if err != nil {
+		return 0, err
+	}
+
+	var cutoff int64
+	if policy.KeepFor > 0 {
+		cutoff = db.NowFunc().Add(-policy.KeepFor).UnixMilli()
+	}
+
+	var ids []uint
+	kept := map[uint]int{}
+	for _, row := range rows {
+		parentID := *row.AuditParentID
+		if kept[parentID] < policy.KeepVersions {
+			kept[parentID]++
+			continue
+		}
+		if policy.KeepFor > 0 && row.DeletedAt >= cutoff {
+			continue
+		}
+		ids = append(ids, row.ID)
+	}
+
+	if err := recordAnchors(db, auditSchema.Table, ids); err != nil {
+		return 0, err
+	}
+
+	return inBatches(db, ids, policy.BatchSize, func(tx *gorm.DB, batch []uint) (int64, error) {
+		result := AllowBypass(tx).Exec("DELETE FROM ? WHERE id IN ?", clause.Table{Name: auditSchema.Table}, batch)
+		return result.RowsAffected, result.Error
+	})
+}
+
+// supersededVersions returns the superseded versions of table, except the originals and the last version
+// of each record, which is also deleted when the record is, grouped by audit parent from the most recent to the oldest one.
+func supersededVersions(db *gorm.DB, table string) ([]versionRow, error) {
+	last := db.Table(table).Select("MAX(id)").Where("audit_parent_id IS NOT NULL").Group("audit_parent_id")
+
+	var rows []versionRow
+	err := db.Table(table).
+		Select("id", "audit_parent_id", "deleted_at").
+		Where("deleted_at <> 0 AND audit_parent_id IS NOT NULL AND id NOT IN (?)", last).
+		Order("audit_parent_id, id desc").
+		Find(&rows).Error
+	return rows, err
+}
+
+// inBatches calls fn with ids split in batches of size, each one inside its own transaction.
+func inBatches(db *gorm.DB, ids []uint, size int, fn func(tx *gorm.DB, batch []uint) (int64, error)) (int64, error) {
+	if size <= 0 {
+		size = defaultBatchSize
+	}
+
+	var total int64
+	for start := 0; start < len(ids); start += size {
+		end := start + size
+		if end > len(ids) {
+			end = len(ids)
+		}
+
+		var affected int64
+		err := db.Transaction(func(tx *gorm.DB) (err error) {
+			affected, err = fn(tx, ids[start:end])
+			return err
+		})
+		if err != nil {
+			return total, err
+		}
+		total += affected
+	}
+	return total, nil
+}
@@ -0,0 +1,211 @@
+package MegaGormAudit
+
+import (
+	"context"
+	"errors"
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+	"testing"
+	"time"
+)
+
+type PlayerWithRetention struct {
+	AuditableModel
+	Name   string
+	policy RetentionPolicy
+}
+
+func (p PlayerWithRetention) AuditRetentionPolicy() RetentionPolicy {
+	return p.policy
+}
+
+type unsupportedRetention string
+
+func (unsupportedRetention) AuditRetentionPolicy() RetentionPolicy {
+	return RetentionPolicy{KeepVersions: 1}
+}
+
+func TestPurge(t *testing.T) {
+
+	tests := []struct {
+		name       string
+		model      interface{}
+		updates    int
+		delete     bool
+		purgeAfter time.Duration
+		wantPurged int64
+		wantIDs    []uint
+		wantErr    error
+	}{
+		{
+			name:       "Success, keep versions",
+			model:      &PlayerWithRetention{Name: "teste", policy: RetentionPolicy{KeepVersions: 1}},
+			updates:    4,
+			wantPurged: 2,
+			wantIDs:    []uint{1, 4, 5},
+		},
+		{
+			name:       "Success, keep one version of three updates",
+			model:      &PlayerWithRetention{Name: "teste", policy: RetentionPolicy{KeepVersions: 1}},
+			updates:    3,
+			wantPurged: 1,
+			wantIDs:    []uint{1, 3, 4},
+		},
+		{
+			name:       "Success, keep versions in small batches",
+			model:      &PlayerWithRetention{Name: "teste", policy: RetentionPolicy{KeepVersions: 1, BatchSize: 1}},
+			updates:    5,
+			wantPurged: 3,
+			wantIDs:    []uint{1, 5, 6},
+		},
+		{
+			name:       "Success, recent versions are kept",
+			model:      &PlayerWithRetention{Name: "teste", policy: RetentionPolicy{KeepFor: time.Hour}},
+			updates:    3,
+			wantPurged: 0,
+			wantIDs:    []uint{1, 2, 3, 4},
+		},
+		{
+			name:       "Success, last version of deleted record is kept",
+			model:      &PlayerWithRetention{Name: "teste", policy: RetentionPolicy{KeepVersions: 1}},
+			updates:    3,
+			delete:     true,
+			wantPurged: 1,
+			wantIDs:    []uint{1, 3, 4},
+		},
+		{
+			name:       "Success, last version of deleted record is kept after its time",
+			model:      &PlayerWithRetention{Name: "teste", policy: RetentionPolicy{KeepFor: time.Hour}},
+			updates:    2,
+			delete:     true,
+			purgeAfter: 2 * time.Hour,
+			wantPurged: 1,
+			wantIDs:    []uint{1, 3},
+		},
+		{
+			name:       "Success, without versions",
+			model:      &PlayerWithRetention{Name: "teste", policy: RetentionPolicy{KeepVersions: 1}},
+			updates:    0,
+			wantPurged: 0,
+			wantIDs:    []uint{1},
+		},
+		{
+			name:    "Fail without retention policy",
+			model:   &PlayerWithRetention{Name: "teste"},
+			wantIDs: []uint{1},
+			wantErr: ErrNoRetentionPolicy,
+		},
+		{
+			name:    "Fail on model without retention",
+			model:   &PlayerErrorOnCreate{},
+			wantErr: ErrNoRetentionPolicy,
+		},
+		{
+			name:    "Fail on model that can't be parsed",
+			model:   unsupportedRetention("teste"),
+			wantErr: schema.ErrUnsupportedDataType,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, err := createDatabase()
+			if err != nil {
+				t.Errorf("createDatabase() error = %v", err)
+				return
+			}
+
+			player, ok := tt.model.(*PlayerWithRetention)
+			if !ok {
+				_, err = Purge(context.Background(), db, tt.model)
+				if !errors.Is(err, tt.wantErr) {
+					t.Errorf("Purge() error = %v, wantErr %v", err, tt.wantErr)
+				}
+				return
+			}
+
+			err = db.AutoMigrate(PlayerWithRetention{})
+			if err != nil {
+				t.Errorf("AutoMigrate() error = %v", err)
+				return
+			}
+
+			err = db.Create(player).Error
+			if err != nil {
+				t.Errorf("Create() error = %v", err)
+				return
+			}
+
+			for i := 0; i < tt.updates; i++ {
+				player.Name = "teste atualizado"
+				err = db.Updates(player).Error
+				if err != nil {
+					t.Errorf("Updates() error = %v", err)
+					return
+				}
+			}
+
+			if tt.delete {
+				err = db.Delete(player).Error
+				if err != nil {
+					t.Errorf("Delete() error = %v", err)
+					return
+				}
+			}
+
+			purgeDB := db.Session(&gorm.Session{NowFunc: func() time.Time {
+				return time.Now().Add(tt.purgeAfter)
+			}})
+			purged, err := Purge(context.Background(), purgeDB, player)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("Purge() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if purged != tt.wantPurged {
+				t.Errorf("Purge() = %v, want %v", purged, tt.wantPurged)
+			}
+
+			var ids []uint
+			db.Unscoped().Model(&PlayerWithRetention{}).Order("id").Pluck("id", &ids)
+			if !equalIDs(ids, tt.wantIDs) {
+				t.Errorf("ids = %v, want %v", ids, tt.wantIDs)
+			}
+
+			tampered, err := Verify(context.Background(), db, player)
+			if err != nil || len(tampered) > 0 {
+				t.Errorf("Verify() = %v, %v, want no tampered versions", tampered, err)
+			}
+		})
+	}
+}
+
+func TestPurge_FailOnDatabase(t *testing.T) {
+	db, err := createDatabase()
+	if err != nil {
+		t.Errorf("createDatabase() error = %v", err)
+		return
+	}
+
+	_, err = Purge(context.Background(), db, &PlayerWithRetention{policy: RetentionPolicy{KeepVersions: 1}})
+	if err == nil {
+		t.Errorf("Purge() error = nil, want error")
+	}
+
+	_, err = inBatches(db, []uint{1}, 0, func(tx *gorm.DB, batch []uint) (int64, error) {
+		return 0, errors.New("batch error")
+	})
+	if err == nil {
+		t.Errorf("inBatches() error = nil, want error")
+	}
+}
+
+func equalIDs(ids, want []uint) bool {
+	if len(ids) != len(want) {
+		return false
+	}
+	for i := range ids {
+		if ids[i] != want[i] {
+			return false
+		}
+	}
+	return true
+}
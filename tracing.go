@@ -0,0 +1,70 @@
+package MegaGormAudit
+
+import (
+	"context"
+	"gorm.io/gorm"
+)
+
+const (
+	SpanUpdate    = "megagormaudit.update"
+	SpanSupersede = "megagormaudit.supersede"
+	SpanInsert    = "megagormaudit.insert"
+
+	AttributeTable    = "db.sql.table"
+	AttributeEntityID = "megagormaudit.entity_id"
+	AttributeVersion  = "megagormaudit.version"
+)
+
+// Tracer starts the spans of audited writes. Its methods mirror the OpenTelemetry trace API,
+// so an OpenTelemetry tracer can be used through a small adapter.
+type Tracer interface {
+	Start(ctx context.Context, name string) (context.Context, Span)
+}
+
+// Span is a traced step of an audited write.
+type Span interface {
+	SetAttribute(key string, value interface{})
+	RecordError(err error)
+	End()
+}
+
+type noopSpan struct{}
+
+func (noopSpan) SetAttribute(string, interface{}) {}
+
+func (noopSpan) RecordError(error) {}
+
+func (noopSpan) End() {}
+
+// startSpan starts the span name of the version of an entity with the plugin Tracer.
+func (a MegaGormAuditPlugin) startSpan(ctx context.Context, name, table string, entityID, version uint) (context.Context, Span) {
+	if a.Tracer == nil {
+		return ctx, noopSpan{}
+	}
+
+	ctx, span := a.Tracer.Start(ctx, name)
+	span.SetAttribute(AttributeTable, table)
+	span.SetAttribute(AttributeEntityID, entityID)
+	span.SetAttribute(AttributeVersion, version)
+	return ctx, span
+}
+
+// traced runs step with tx in the context of the span name, ending it with the error of step.
+func (a MegaGormAuditPlugin) traced(tx *gorm.DB, name, table string, entityID, version uint, step func(tx *gorm.DB, span Span) error) error {
+	if a.Tracer == nil {
+		return step(tx, noopSpan{})
+	}
+
+	ctx, span := a.startSpan(tx.Statement.Context, name, table, entityID, version)
+	err := step(tx.WithContext(ctx), span)
+	endSpan(span, err)
+	return err
+}
+
+// endSpan records err, when set, and ends span.
+func endSpan(span Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+	}
+	span.End()
+}
@@ -0,0 +1,126 @@
+package MegaGormAudit
+
+import (
+	"context"
+	"errors"
+	"gorm.io/gorm"
+	"reflect"
+	"testing"
+)
+
+type spanParentKey struct{}
+
+type recordedSpan struct {
+	name       string
+	parent     string
+	attributes map[string]interface{}
+	err        error
+	ended      bool
+}
+
+type recordingTracer struct {
+	spans []*recordedSpan
+}
+
+func (r *recordingTracer) Start(ctx context.Context, name string) (context.Context, Span) {
+	parent, _ := ctx.Value(spanParentKey{}).(string)
+	span := &recordedSpan{name: name, parent: parent, attributes: map[string]interface{}{}}
+	r.spans = append(r.spans, span)
+	return context.WithValue(ctx, spanParentKey{}, name), span
+}
+
+func (s *recordedSpan) SetAttribute(key string, value interface{}) {
+	s.attributes[key] = value
+}
+
+func (s *recordedSpan) RecordError(err error) {
+	s.err = err
+}
+
+func (s *recordedSpan) End() {
+	s.ended = true
+}
+
+func TestAuditPlugin_Tracer(t *testing.T) {
+
+	tests := []struct {
+		name      string
+		change    func(db *gorm.DB, account *Account) error
+		wantErr   error
+		wantSpans []recordedSpan
+	}{
+		{
+			name: "Success, spans of update steps",
+			change: func(db *gorm.DB, account *Account) error {
+				account.Balance = 20
+				if err := db.Updates(account).Error; err != nil {
+					return err
+				}
+				account.Balance = 30
+				return db.Updates(account).Error
+			},
+			wantSpans: []recordedSpan{
+				{name: SpanUpdate, attributes: map[string]interface{}{AttributeTable: "accounts", AttributeEntityID: uint(1), AttributeVersion: uint(1)}},
+				{name: SpanSupersede, parent: SpanUpdate, attributes: map[string]interface{}{AttributeTable: "accounts", AttributeEntityID: uint(1), AttributeVersion: uint(1)}},
+				{name: SpanInsert, parent: SpanUpdate, attributes: map[string]interface{}{AttributeTable: "accounts", AttributeEntityID: uint(1), AttributeVersion: uint(2)}},
+				{name: SpanUpdate, attributes: map[string]interface{}{AttributeTable: "accounts", AttributeEntityID: uint(1), AttributeVersion: uint(2)}},
+				{name: SpanSupersede, parent: SpanUpdate, attributes: map[string]interface{}{AttributeTable: "accounts", AttributeEntityID: uint(1), AttributeVersion: uint(2)}},
+				{name: SpanInsert, parent: SpanUpdate, attributes: map[string]interface{}{AttributeTable: "accounts", AttributeEntityID: uint(1), AttributeVersion: uint(3)}},
+			},
+		},
+		{
+			name: "Fail on update with the error recorded",
+			change: func(db *gorm.DB, account *Account) error {
+				account.Balance = 20
+				db.Updates(account)
+				account.ID = 1
+				return db.Updates(account).Error
+			},
+			wantErr: ErrImmutableVersion,
+			wantSpans: []recordedSpan{
+				{name: SpanUpdate, attributes: map[string]interface{}{AttributeTable: "accounts", AttributeEntityID: uint(1), AttributeVersion: uint(1)}},
+				{name: SpanSupersede, parent: SpanUpdate, attributes: map[string]interface{}{AttributeTable: "accounts", AttributeEntityID: uint(1), AttributeVersion: uint(1)}},
+				{name: SpanInsert, parent: SpanUpdate, attributes: map[string]interface{}{AttributeTable: "accounts", AttributeEntityID: uint(1), AttributeVersion: uint(2)}},
+				{name: SpanUpdate, attributes: map[string]interface{}{AttributeTable: "accounts", AttributeEntityID: uint(1), AttributeVersion: uint(1)}, err: ErrImmutableVersion},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tracer := &recordingTracer{}
+			db, err := createDatabaseWithPlugin(MegaGormAuditPlugin{Tracer: tracer})
+			if err != nil {
+				t.Errorf("createDatabase() error = %v", err)
+				return
+			}
+
+			err = db.AutoMigrate(Account{})
+			if err != nil {
+				t.Errorf("AutoMigrate() error = %v", err)
+				return
+			}
+
+			account := &Account{Owner: "alice", Balance: 10}
+			db.Create(account)
+
+			err = tt.change(db, account)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("change() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+
+			if len(tracer.spans) != len(tt.wantSpans) {
+				t.Errorf("spans = %v, want %v", len(tracer.spans), len(tt.wantSpans))
+				return
+			}
+			for i, span := range tracer.spans {
+				want := tt.wantSpans[i]
+				want.ended = true
+				if span.name != want.name || span.parent != want.parent || !reflect.DeepEqual(span.attributes, want.attributes) ||
+					!errors.Is(span.err, want.err) || !span.ended {
+					t.Errorf("span %d = %+v, want %+v", i, *span, want)
+				}
+			}
+		})
+	}
+}
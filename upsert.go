@@ -0,0 +1,139 @@
+package MegaGormAudit
+
+import (
+	"errors"
+	"fmt"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"reflect"
+)
+
+var ErrUnsupportedUpsert = errors.New("megagormaudit: upsert not supported on auditable models")
+
+// versionUpserts wraps the gorm create callback so an upsert of an auditable model conflicting with
+// a live row supersedes it with a new version, instead of overwriting it in place.
+func versionUpserts(create func(*gorm.DB)) func(*gorm.DB) {
+	return func(db *gorm.DB) {
+		c, ok := db.Statement.Clauses["ON CONFLICT"]
+		onConflict, isOnConflict := c.Expression.(clause.OnConflict)
+		if db.Error != nil || !ok || !isOnConflict || onConflict.DoNothing || db.Statement.Schema == nil || !isAuditableSchema(db.Statement.Schema) {
+			create(db)
+			return
+		}
+
+		if kind := db.Statement.ReflectValue.Kind(); kind == reflect.Slice || kind == reflect.Array {
+			for i := 0; i < db.Statement.ReflectValue.Len(); i++ {
+				_, err := conflictingVersion(db, onConflict, reflect.Indirect(db.Statement.ReflectValue.Index(i)))
+				if err == nil {
+					err = fmt.Errorf("%w: only a single model conflicting with a live version can be upserted", ErrUnsupportedUpsert)
+				}
+				if !errors.Is(err, gorm.ErrRecordNotFound) {
+					db.AddError(err)
+					return
+				}
+			}
+
+			delete(db.Statement.Clauses, "ON CONFLICT")
+			create(db)
+			return
+		}
+
+		live, err := conflictingVersion(db, onConflict, db.Statement.ReflectValue)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			delete(db.Statement.Clauses, "ON CONFLICT")
+			create(db)
+			return
+		}
+		if err != nil {
+			db.AddError(err)
+			return
+		}
+
+		if err := applyUpsert(db.Statement, onConflict, reflect.Indirect(reflect.ValueOf(live))); err != nil {
+			db.AddError(err)
+			return
+		}
+
+		result := db.Session(&gorm.Session{NewDB: true}).Updates(db.Statement.Model)
+		//the new version was inserted, and recorded, by the update
+		db.Statement.Settings.Store(versionInsertKey, true)
+		if result.Error != nil {
+			db.AddError(result.Error)
+			return
+		}
+		db.RowsAffected = result.RowsAffected
+	}
+}
+
+// conflictingVersion loads the live version with the same values of the conflict columns, or of the primary key, of value.
+// Without a live version the insert is safe, since conflicts with superseded versions fail instead of overwriting them.
+func conflictingVersion(db *gorm.DB, onConflict clause.OnConflict, value reflect.Value) (interface{}, error) {
+	stmt := db.Statement
+	var names []string
+	for _, column := range onConflict.Columns {
+		names = append(names, column.Name)
+	}
+	if len(names) == 0 {
+		names = stmt.Schema.PrimaryFieldDBNames
+	}
+
+	conditions := map[string]interface{}{}
+	for _, name := range names {
+		field := stmt.Schema.LookUpField(name)
+		if field == nil {
+			return nil, fmt.Errorf("%w: unknown conflict column %s", ErrUnsupportedUpsert, name)
+		}
+
+		fieldValue, zero := field.ValueOf(stmt.Context, value)
+		if zero && field.PrimaryKey {
+			return nil, gorm.ErrRecordNotFound
+		}
+		conditions[field.DBName] = fieldValue
+	}
+
+	live := reflect.New(stmt.Schema.ModelType).Interface()
+	err := db.Session(&gorm.Session{NewDB: true, SkipHooks: true}).Where(conditions).Take(live).Error
+	return live, err
+}
+
+// applyUpsert sets the model of the statement to the version resulting from the upsert of live:
+// all the columns with UpdateAll, otherwise the live columns with the assignments of DoUpdates.
+func applyUpsert(stmt *gorm.Statement, onConflict clause.OnConflict, live reflect.Value) error {
+	ctx := stmt.Context
+	if !onConflict.UpdateAll {
+		excluded := map[string]bool{}
+		values := map[string]interface{}{}
+		for _, assignment := range onConflict.DoUpdates {
+			switch value := assignment.Value.(type) {
+			case clause.Column:
+				if value.Table != "excluded" {
+					return fmt.Errorf("%w: assignment of %s", ErrUnsupportedUpsert, assignment.Column.Name)
+				}
+				excluded[value.Name] = true
+			case clause.Expression:
+				return fmt.Errorf("%w: assignment of %s", ErrUnsupportedUpsert, assignment.Column.Name)
+			default:
+				values[assignment.Column.Name] = value
+			}
+		}
+
+		for _, field := range stmt.Schema.Fields {
+			if field.DBName == "" || excluded[field.DBName] {
+				continue
+			}
+
+			value, ok := values[field.DBName]
+			if !ok {
+				value, _ = field.ValueOf(ctx, live)
+			}
+			if err := field.Set(ctx, stmt.ReflectValue, value); err != nil {
+				return fmt.Errorf("%w: assignment of %s: %w", ErrUnsupportedUpsert, field.DBName, err)
+			}
+		}
+	}
+
+	auditableModel := stmt.ReflectValue.FieldByName("AuditableModel").Addr().Interface().(*AuditableModel)
+	liveModel := live.FieldByName("AuditableModel").Interface().(AuditableModel)
+	auditableModel.ID, auditableModel.AuditParentID = liveModel.ID, liveModel.AuditParentID
+	return nil
+}
@@ -0,0 +1,226 @@
+package MegaGormAudit
+
+import (
+	"errors"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"testing"
+)
+
+func TestAuditPlugin_Upsert(t *testing.T) {
+
+	type Subscriber struct {
+		AuditableModel
+		Email string
+		Name  string
+		Plan  string
+	}
+
+	unchangedSubscriber := func(db *gorm.DB) bool {
+		var subscribers []Subscriber
+		db.Unscoped().Find(&subscribers)
+		return len(subscribers) == 1 && subscribers[0].Name == "subscriber"
+	}
+
+	tests := []struct {
+		name        string
+		pattern     string
+		upsert      func(db *gorm.DB) error
+		wantErr     error
+		successTest func(db *gorm.DB) bool
+	}{
+		{
+			name: "Success, update all on primary key conflict",
+			upsert: func(db *gorm.DB) error {
+				subscriber := &Subscriber{AuditableModel: AuditableModel{ID: 1}, Email: "a@b.com", Name: "updated", Plan: "silver"}
+				return db.Clauses(clause.OnConflict{UpdateAll: true}).Create(subscriber).Error
+			},
+			successTest: func(db *gorm.DB) bool {
+				var subscribers []Subscriber
+				db.Unscoped().Order("id").Find(&subscribers)
+				return len(subscribers) == 2 && subscribers[0].Name == "subscriber" && subscribers[0].DeletedAt > 0 &&
+					subscribers[1].Name == "updated" && subscribers[1].Plan == "silver" &&
+					*subscribers[1].AuditParentID == 1 && subscribers[1].DeletedAt == 0
+			},
+		},
+		{
+			name: "Success, update columns on conflict column",
+			upsert: func(db *gorm.DB) error {
+				subscriber := &Subscriber{Email: "a@b.com", Name: "updated", Plan: "silver"}
+				return db.Clauses(clause.OnConflict{
+					Columns:   []clause.Column{{Name: "email"}},
+					DoUpdates: clause.AssignmentColumns([]string{"name"}),
+				}).Create(subscriber).Error
+			},
+			successTest: func(db *gorm.DB) bool {
+				var subscribers []Subscriber
+				db.Find(&subscribers)
+				return len(subscribers) == 1 && subscribers[0].ID == 2 && subscribers[0].Name == "updated" && subscribers[0].Plan == "gold"
+			},
+		},
+		{
+			name: "Success, update values on conflict column",
+			upsert: func(db *gorm.DB) error {
+				subscriber := &Subscriber{Email: "a@b.com", Name: "updated"}
+				return db.Clauses(clause.OnConflict{
+					Columns:   []clause.Column{{Name: "email"}},
+					DoUpdates: clause.Assignments(map[string]interface{}{"plan": "platinum"}),
+				}).Create(subscriber).Error
+			},
+			successTest: func(db *gorm.DB) bool {
+				var subscribers []Subscriber
+				db.Find(&subscribers)
+				return len(subscribers) == 1 && subscribers[0].ID == 2 && subscribers[0].Name == "subscriber" && subscribers[0].Plan == "platinum"
+			},
+		},
+		{
+			name: "Success, create without conflict",
+			upsert: func(db *gorm.DB) error {
+				subscriber := &Subscriber{Email: "c@d.com", Name: "other"}
+				return db.Clauses(clause.OnConflict{Columns: []clause.Column{{Name: "email"}}, UpdateAll: true}).Create(subscriber).Error
+			},
+			successTest: func(db *gorm.DB) bool {
+				var subscribers []Subscriber
+				db.Find(&subscribers)
+				return len(subscribers) == 2
+			},
+		},
+		{
+			name: "Success, do nothing on conflict",
+			upsert: func(db *gorm.DB) error {
+				subscriber := &Subscriber{AuditableModel: AuditableModel{ID: 1}, Email: "a@b.com", Name: "updated"}
+				return db.Clauses(clause.OnConflict{DoNothing: true}).Create(subscriber).Error
+			},
+			successTest: func(db *gorm.DB) bool {
+				var subscribers []Subscriber
+				db.Unscoped().Find(&subscribers)
+				return len(subscribers) == 1 && subscribers[0].Name == "subscriber"
+			},
+		},
+		{
+			name: "Success, create multiple models without conflict",
+			upsert: func(db *gorm.DB) error {
+				subscribers := []Subscriber{{Email: "c@d.com"}, {Email: "e@f.com"}}
+				return db.Clauses(clause.OnConflict{Columns: []clause.Column{{Name: "email"}}, UpdateAll: true}).Create(&subscribers).Error
+			},
+			successTest: func(db *gorm.DB) bool {
+				var subscribers []Subscriber
+				db.Find(&subscribers)
+				return len(subscribers) == 3
+			},
+		},
+		{
+			name: "Fail on upsert of multiple models",
+			upsert: func(db *gorm.DB) error {
+				subscribers := []Subscriber{{AuditableModel: AuditableModel{ID: 1}, Name: "updated"}}
+				return db.Clauses(clause.OnConflict{UpdateAll: true}).Create(&subscribers).Error
+			},
+			wantErr: ErrUnsupportedUpsert,
+			successTest: func(db *gorm.DB) bool {
+				var subscribers []Subscriber
+				db.Unscoped().Find(&subscribers)
+				return len(subscribers) == 1 && subscribers[0].Name == "subscriber"
+			},
+		},
+		{
+			name: "Fail on expression assignment",
+			upsert: func(db *gorm.DB) error {
+				subscriber := &Subscriber{AuditableModel: AuditableModel{ID: 1}}
+				return db.Clauses(clause.OnConflict{
+					DoUpdates: clause.Set{{Column: clause.Column{Name: "name"}, Value: gorm.Expr("name || ?", "!")}},
+				}).Create(subscriber).Error
+			},
+			wantErr: ErrUnsupportedUpsert,
+			successTest: func(db *gorm.DB) bool {
+				var subscribers []Subscriber
+				db.Unscoped().Find(&subscribers)
+				return len(subscribers) == 1 && subscribers[0].Name == "subscriber"
+			},
+		},
+		{
+			name: "Fail on unknown conflict column",
+			upsert: func(db *gorm.DB) error {
+				subscriber := &Subscriber{Email: "a@b.com", Name: "updated"}
+				return db.Clauses(clause.OnConflict{Columns: []clause.Column{{Name: "missing"}}, UpdateAll: true}).Create(subscriber).Error
+			},
+			wantErr:     ErrUnsupportedUpsert,
+			successTest: unchangedSubscriber,
+		},
+		{
+			name: "Fail on assignment of another table column",
+			upsert: func(db *gorm.DB) error {
+				subscriber := &Subscriber{AuditableModel: AuditableModel{ID: 1}}
+				return db.Clauses(clause.OnConflict{
+					DoUpdates: clause.Set{{Column: clause.Column{Name: "name"}, Value: clause.Column{Table: "subscribers", Name: "plan"}}},
+				}).Create(subscriber).Error
+			},
+			wantErr:     ErrUnsupportedUpsert,
+			successTest: unchangedSubscriber,
+		},
+		{
+			name: "Fail on assignment of invalid value",
+			upsert: func(db *gorm.DB) error {
+				subscriber := &Subscriber{AuditableModel: AuditableModel{ID: 1}}
+				return db.Clauses(clause.OnConflict{
+					DoUpdates: clause.Assignments(map[string]interface{}{"name": struct{}{}}),
+				}).Create(subscriber).Error
+			},
+			wantErr:     ErrUnsupportedUpsert,
+			successTest: unchangedSubscriber,
+		},
+		{
+			name:    "Fail loading the live version",
+			pattern: "^SELECT \\* FROM `subscribers` WHERE",
+			upsert: func(db *gorm.DB) error {
+				subscriber := &Subscriber{AuditableModel: AuditableModel{ID: 1}, Name: "updated"}
+				return db.Clauses(clause.OnConflict{UpdateAll: true}).Create(subscriber).Error
+			},
+			wantErr:     errFailingStatement,
+			successTest: unchangedSubscriber,
+		},
+		{
+			name:    "Fail superseding the live version",
+			pattern: "^UPDATE `subscribers`",
+			upsert: func(db *gorm.DB) error {
+				subscriber := &Subscriber{AuditableModel: AuditableModel{ID: 1}, Name: "updated"}
+				return db.Clauses(clause.OnConflict{UpdateAll: true}).Create(subscriber).Error
+			},
+			wantErr:     errFailingStatement,
+			successTest: unchangedSubscriber,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, err := createDatabase()
+			if err != nil {
+				t.Errorf("createDatabase() error = %v", err)
+				return
+			}
+
+			err = db.AutoMigrate(Subscriber{})
+			if err != nil {
+				t.Errorf("AutoMigrate() error = %v", err)
+				return
+			}
+
+			err = db.Create(&Subscriber{Email: "a@b.com", Name: "subscriber", Plan: "gold"}).Error
+			if err != nil {
+				t.Errorf("Create() error = %v", err)
+				return
+			}
+
+			if tt.pattern != "" {
+				failOn(db, tt.pattern)
+			}
+			err = tt.upsert(db)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("upsert() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+
+			if !tt.successTest(db) {
+				t.Errorf("successTest() = false, want true")
+			}
+		})
+	}
+}
@@ -0,0 +1,135 @@
+package MegaGormAudit
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gorm.io/gorm"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	WebhookSignatureHeader = "X-Audit-Signature"
+	WebhookEventHeader     = "X-Audit-Event-ID"
+
+	signaturePrefix        = "sha256="
+	defaultWebhookAttempts = 3
+	defaultWebhookBackoff  = 500 * time.Millisecond
+)
+
+var ErrWebhookStatus = errors.New("megagormaudit: webhook responded with an error status")
+
+// WebhookDeadLetter stores an event the WebhookPublisher failed to deliver after all its attempts.
+type WebhookDeadLetter struct {
+	ID        uint `gorm:"primarykey"`
+	CreatedAt time.Time
+	EventID   uint
+	URL       string
+	Payload   string //JSON AuditEvent
+	Attempts  int
+	LastError string
+}
+
+func (WebhookDeadLetter) TableName() string {
+	return "audit_webhook_dead_letters"
+}
+
+// WebhookPublisher is a Publisher that POSTs audit events as JSON to an URL, signed with an HMAC-SHA256
+// of the body in the X-Audit-Signature header, retrying failed deliveries with exponential backoff.
+type WebhookPublisher struct {
+	URL         string
+	Secret      []byte        //HMAC key shared with the receiver
+	Filter      EventFilter   //events posted, the others are ignored
+	Client      *http.Client  //http.DefaultClient when nil
+	MaxAttempts int           //attempts of each event, 3 when zero
+	Backoff     time.Duration //wait before the second attempt, doubled on each retry, 500ms when zero
+	DeadLetters *gorm.DB      //stores undelivered events in WebhookDeadLetter instead of failing, so they don't block the relay
+}
+
+func (w *WebhookPublisher) Publish(ctx context.Context, event AuditEvent) error {
+	if !w.Filter.match(event) {
+		return nil
+	}
+
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	attempts := w.MaxAttempts
+	if attempts <= 0 {
+		attempts = defaultWebhookAttempts
+	}
+	backoff := w.Backoff
+	if backoff <= 0 {
+		backoff = defaultWebhookBackoff
+	}
+
+	for attempt := 1; ; attempt++ {
+		err = w.post(ctx, event, payload)
+		if err == nil || attempt == attempts || ctx.Err() != nil {
+			break
+		}
+
+		select {
+		case <-ctx.Done():
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+
+	if err == nil || w.DeadLetters == nil || ctx.Err() != nil {
+		return err
+	}
+	return w.DeadLetters.WithContext(ctx).Create(&WebhookDeadLetter{
+		EventID:   event.ID,
+		URL:       w.URL,
+		Payload:   string(payload),
+		Attempts:  attempts,
+		LastError: err.Error(),
+	}).Error
+}
+
+func (w *WebhookPublisher) post(ctx context.Context, event AuditEvent, payload []byte) error {
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, w.URL, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set(WebhookSignatureHeader, WebhookSignature(w.Secret, payload))
+	request.Header.Set(WebhookEventHeader, strconv.FormatUint(uint64(event.ID), 10))
+
+	client := w.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	response, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return fmt.Errorf("%w: %d", ErrWebhookStatus, response.StatusCode)
+	}
+	return nil
+}
+
+// WebhookSignature returns the X-Audit-Signature header value of payload signed with secret.
+func WebhookSignature(secret, payload []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(payload)
+	return signaturePrefix + hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifyWebhookSignature reports whether signature is the X-Audit-Signature of payload signed with secret.
+func VerifyWebhookSignature(secret, payload []byte, signature string) bool {
+	return hmac.Equal([]byte(WebhookSignature(secret, payload)), []byte(signature))
+}
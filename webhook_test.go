@@ -0,0 +1,224 @@
+package MegaGormAudit
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+type webhookServer struct {
+	mu       sync.Mutex
+	secret   []byte
+	failures int
+	requests int
+	events   []AuditEvent
+}
+
+func (s *webhookServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests++
+
+	body, _ := io.ReadAll(r.Body)
+	if !VerifyWebhookSignature(s.secret, body, r.Header.Get(WebhookSignatureHeader)) {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	if s.failures > 0 {
+		s.failures--
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+
+	var event AuditEvent
+	json.Unmarshal(body, &event)
+	s.events = append(s.events, event)
+}
+
+func TestWebhookPublisher_Publish(t *testing.T) {
+
+	tests := []struct {
+		name            string
+		failures        int
+		secret          []byte
+		filter          EventFilter
+		deadLetters     bool
+		wantErr         error
+		wantRequests    int
+		wantEvents      int
+		wantDeadLetters int64
+	}{
+		{
+			name:         "Success, signed event posted",
+			wantRequests: 1,
+			wantEvents:   1,
+		},
+		{
+			name:         "Success, retried after failures",
+			failures:     2,
+			wantRequests: 3,
+			wantEvents:   1,
+		},
+		{
+			name:         "Success, event ignored by the filter",
+			filter:       EventFilter{Operations: []string{EventDelete}},
+			wantRequests: 0,
+		},
+		{
+			name:            "Success, undelivered event stored as dead letter",
+			failures:        3,
+			deadLetters:     true,
+			wantRequests:    3,
+			wantDeadLetters: 1,
+		},
+		{
+			name:         "Fail on undelivered event without dead letters",
+			failures:     3,
+			wantErr:      ErrWebhookStatus,
+			wantRequests: 3,
+		},
+		{
+			name:         "Fail on wrong signature",
+			secret:       []byte("other secret"),
+			wantErr:      ErrWebhookStatus,
+			wantRequests: 3,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, err := createDatabase()
+			if err != nil {
+				t.Errorf("createDatabase() error = %v", err)
+				return
+			}
+
+			err = db.AutoMigrate(WebhookDeadLetter{})
+			if err != nil {
+				t.Errorf("AutoMigrate() error = %v", err)
+				return
+			}
+
+			handler := &webhookServer{secret: []byte("secret"), failures: tt.failures}
+			server := httptest.NewServer(handler)
+			defer server.Close()
+
+			secret := tt.secret
+			if secret == nil {
+				secret = handler.secret
+			}
+			publisher := &WebhookPublisher{URL: server.URL, Secret: secret, Filter: tt.filter, Backoff: time.Millisecond}
+			if tt.deadLetters {
+				publisher.DeadLetters = db
+			}
+
+			event := AuditEvent{ID: 7, Entity: "accounts", Operation: EventUpdate, EntityID: 1, OldVersionID: 1, NewVersionID: 2}
+			err = publisher.Publish(context.Background(), event)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("Publish() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+
+			if handler.requests != tt.wantRequests || len(handler.events) != tt.wantEvents {
+				t.Errorf("requests = %v, events = %v, want %v, %v", handler.requests, len(handler.events), tt.wantRequests, tt.wantEvents)
+			}
+			if tt.wantEvents > 0 && handler.events[0].ID != event.ID {
+				t.Errorf("event ID = %v, want %v", handler.events[0].ID, event.ID)
+			}
+			if deadLetters := countRows(db, "audit_webhook_dead_letters"); deadLetters != tt.wantDeadLetters {
+				t.Errorf("dead letters = %v, want %v", deadLetters, tt.wantDeadLetters)
+			}
+		})
+	}
+}
+
+func TestWebhookPublisher_Relay(t *testing.T) {
+	db, err := createDatabaseWithPlugin(MegaGormAuditPlugin{Outbox: true})
+	if err != nil {
+		t.Errorf("createDatabase() error = %v", err)
+		return
+	}
+
+	err = db.AutoMigrate(Account{}, OutboxEvent{})
+	if err != nil {
+		t.Errorf("AutoMigrate() error = %v", err)
+		return
+	}
+
+	account := &Account{Owner: "alice", Balance: 10}
+	db.Create(account)
+	account.Balance = 20
+	db.Updates(account)
+
+	handler := &webhookServer{secret: []byte("secret")}
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	relay := &Relay{DB: db, Publisher: &WebhookPublisher{URL: server.URL, Secret: handler.secret}}
+	delivered, err := relay.RelayOnce(context.Background())
+	if err != nil || delivered != 2 {
+		t.Errorf("RelayOnce() = %v, %v, want 2, nil", delivered, err)
+		return
+	}
+	if len(handler.events) != 2 || handler.events[1].Operation != EventUpdate || handler.events[1].ID != 2 {
+		t.Errorf("events = %+v, want create and update", handler.events)
+	}
+}
+
+func TestWebhookPublisher_PublishFail(t *testing.T) {
+
+	tests := []struct {
+		name    string
+		url     string
+		event   AuditEvent
+		backoff time.Duration
+		timeout time.Duration
+		wantErr error
+	}{
+		{
+			name:  "Fail on event that can't be encoded",
+			event: AuditEvent{Diff: map[string]FieldChange{"balance": {New: math.NaN()}}},
+		},
+		{
+			name:    "Fail on invalid URL",
+			url:     "://audit",
+			backoff: time.Millisecond,
+		},
+		{
+			name:    "Fail on context done while waiting to retry",
+			backoff: time.Hour,
+			timeout: 20 * time.Millisecond,
+			wantErr: context.DeadlineExceeded,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := &webhookServer{secret: []byte("secret"), failures: 3}
+			server := httptest.NewServer(handler)
+			defer server.Close()
+
+			url := tt.url
+			if url == "" {
+				url = server.URL
+			}
+			ctx := context.Background()
+			if tt.timeout > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, tt.timeout)
+				defer cancel()
+			}
+
+			publisher := &WebhookPublisher{URL: url, Secret: handler.secret, Backoff: tt.backoff}
+			err := publisher.Publish(ctx, tt.event)
+			if err == nil || (tt.wantErr != nil && !errors.Is(err, tt.wantErr)) {
+				t.Errorf("Publish() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}